@@ -0,0 +1,286 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// This file implements adaptive Huffman coding with Vitter's algorithm, from
+// J. S. Vitter, "Design and Analysis of Dynamic Huffman Codes", JACM 34(4), 1987.
+//
+// The encoder and decoder each maintain a Huffman tree for the symbols seen so far,
+// and update it identically after each symbol, so no code needs to be transmitted.
+// The tree has a zero-weight leaf, the NYT ("not yet transmitted") node.
+// A symbol that has not been seen before is written as the code for the NYT node,
+// followed by the symbol in Elias gamma coding (see [bitWriter.writeGamma]).
+
+// An adaptiveTree is a Huffman tree that changes as symbols are added to it.
+//
+// The nodes are listed in order, with the root first. The list satisfies the
+// sibling property, which characterizes Huffman trees: weights are nonincreasing,
+// and each node's sibling is adjacent to it, at positions 2k+1 and 2k+2.
+// In addition, Vitter's invariant holds: among nodes of equal weight, internal
+// nodes precede leaves. So the key of each node (see [anode.key]) is nonincreasing.
+// The NYT node is always last.
+type adaptiveTree struct {
+	order  []*anode
+	leaves map[Symbol]*anode
+	nyt    *anode
+}
+
+type anode struct {
+	weight int
+	parent *anode
+	child  [2]*anode // both nil for a leaf
+	sym    Symbol    // for a leaf
+	pos    int       // index in adaptiveTree.order
+}
+
+func (n *anode) isLeaf() bool { return n.child[0] == nil }
+
+// key orders nodes: all nodes of a weight follow those of a greater weight,
+// and internal nodes precede leaves of the same weight.
+// Nodes with the same key form a block.
+func (n *anode) key() int {
+	k := 2 * n.weight
+	if !n.isLeaf() {
+		k++
+	}
+	return k
+}
+
+// index returns n's position among its parent's children.
+func (n *anode) index() int {
+	if n.parent.child[0] == n {
+		return 0
+	}
+	return 1
+}
+
+func newAdaptiveTree() *adaptiveTree {
+	nyt := &anode{}
+	return &adaptiveTree{
+		order:  []*anode{nyt},
+		leaves: map[Symbol]*anode{},
+		nyt:    nyt,
+	}
+}
+
+// update adds one occurrence of s to the tree.
+// It is Vitter's procedure Update.
+func (t *adaptiveTree) update(s Symbol) {
+	var leafToIncrement *anode
+	q := t.leaves[s]
+	if q == nil {
+		// Replace the NYT node with an internal node whose children
+		// are a new leaf for s and a new NYT node.
+		q = t.nyt
+		leaf := &anode{parent: q, sym: s, pos: len(t.order)}
+		nyt := &anode{parent: q, pos: len(t.order) + 1}
+		q.child = [2]*anode{leaf, nyt}
+		t.order = append(t.order, leaf, nyt)
+		t.leaves[s] = leaf
+		t.nyt = nyt
+		leafToIncrement = leaf
+	} else {
+		// Interchange q with the leader of its block.
+		t.swapFirst(q, q.key())
+		if q.parent != nil && q.parent.child[1-q.index()] == t.nyt {
+			// q's parent has the same weight as q. Increment it first,
+			// so that q does not move past it.
+			leafToIncrement = q
+			q = q.parent
+		}
+	}
+	for q != nil {
+		q = t.slideAndIncrement(q)
+	}
+	if leafToIncrement != nil {
+		t.slideAndIncrement(leafToIncrement)
+	}
+}
+
+// slideAndIncrement increments the weight of p, first moving it ahead of the nodes
+// that would otherwise precede it with its new weight.
+// It returns the next node to increment.
+// It is Vitter's procedure SlideAndIncrement.
+func (t *adaptiveTree) slideAndIncrement(p *anode) *anode {
+	// Nodes in a block can be permuted freely, so first move p to the front of its block.
+	t.swapFirst(p, p.key())
+	formerParent := p.parent
+	// Vitter shifts each node that p slides past. It is enough to
+	// swap p with the first node of each block it passes.
+	newKey := p.key() + 2
+	for p.pos > 0 {
+		k := t.order[p.pos-1].key()
+		if k >= newKey {
+			break
+		}
+		t.swapFirst(p, k)
+	}
+	p.weight++
+	if p.isLeaf() {
+		return p.parent
+	}
+	return formerParent
+}
+
+// swapFirst swaps p with the first node whose key is k,
+// which must precede p.
+func (t *adaptiveTree) swapFirst(p *anode, k int) {
+	first := sort.Search(p.pos, func(i int) bool { return t.order[i].key() <= k })
+	if first < p.pos {
+		t.swap(p, t.order[first])
+	}
+}
+
+// swap exchanges the positions of a and b, and the subtrees they root.
+// Neither may be the root, and neither may be an ancestor of the other.
+func (t *adaptiveTree) swap(a, b *anode) {
+	if a.parent == b.parent {
+		a.parent.child[0], a.parent.child[1] = a.parent.child[1], a.parent.child[0]
+	} else {
+		ia, ib := a.index(), b.index()
+		a.parent.child[ia] = b
+		b.parent.child[ib] = a
+		a.parent, b.parent = b.parent, a.parent
+	}
+	t.order[a.pos], t.order[b.pos] = b, a
+	a.pos, b.pos = b.pos, a.pos
+}
+
+// An AdaptiveEncoder encodes symbols with adaptive Huffman coding: the code for each symbol
+// depends on the symbols before it. Unlike an [Encoder], it needs no [Code], so the input
+// need not be seen in advance. Use an [AdaptiveDecoder] to decode its output.
+//
+// Errors are sticky, as with an Encoder.
+type AdaptiveEncoder struct {
+	t    *adaptiveTree
+	bw   *bitWriter
+	sp   *splitter // nil if there is no SplitFunc
+	path []uint8   // scratch space for codes
+	err  error
+}
+
+// NewAdaptiveEncoder returns an [AdaptiveEncoder] that writes to w.
+// If split is nil, each byte written with [AdaptiveEncoder.Write] is a symbol.
+func NewAdaptiveEncoder(w io.Writer, split SplitFunc) *AdaptiveEncoder {
+	e := &AdaptiveEncoder{t: newAdaptiveTree(), bw: newBitWriter(w)}
+	if split != nil {
+		e.sp = &splitter{split: split}
+	}
+	return e
+}
+
+// Write encodes data, like [Encoder.Write].
+func (e *AdaptiveEncoder) Write(data []byte) (int, error) {
+	if e.sp == nil {
+		return writeBytes(data, e.bw, e.WriteSymbol)
+	}
+	return writeSplit(data, e.sp, &e.err, e.bw, e.WriteSymbols)
+}
+
+// WriteSymbol writes a symbol to the encoder.
+// Any symbol can be written.
+func (e *AdaptiveEncoder) WriteSymbol(s Symbol) error {
+	if e.err != nil {
+		return e.err
+	}
+	n := e.t.leaves[s]
+	if n == nil {
+		n = e.t.nyt
+	}
+	// Collect the code from the leaf up, then write it from the root down.
+	e.path = e.path[:0]
+	for ; n.parent != nil; n = n.parent {
+		e.path = append(e.path, uint8(n.index()))
+	}
+	for len(e.path) > 0 {
+		var val uint32
+		nbits := min(len(e.path), 32)
+		for i := range nbits {
+			val |= uint32(e.path[len(e.path)-1-i]) << i
+		}
+		e.bw.writeBits(val, nbits)
+		e.path = e.path[:len(e.path)-nbits]
+	}
+	if e.t.leaves[s] == nil {
+		e.bw.writeGamma(s)
+	}
+	e.t.update(s)
+	return nil
+}
+
+// WriteSymbols calls [AdaptiveEncoder.WriteSymbol] for each symbol.
+func (e *AdaptiveEncoder) WriteSymbols(syms []Symbol) error {
+	return writeSymbols(syms, e.WriteSymbol)
+}
+
+// Close passes any unconsumed bytes to the SplitFunc, with atEOF set to true,
+// and writes remaining data to the encoder's writer.
+func (e *AdaptiveEncoder) Close() error {
+	return closeEncoder(e.sp, &e.err, e.bw, e.WriteSymbols)
+}
+
+// An AdaptiveDecoder decodes data encoded by an [AdaptiveEncoder].
+// Use [AdaptiveDecoder.Decode] to decode all the data at once, or call [AdaptiveDecoder.Reset]
+// and then [AdaptiveDecoder.ReadSymbol] to decode a symbol at a time.
+type AdaptiveDecoder struct {
+	t  *adaptiveTree
+	br *bitReader // set by Reset
+}
+
+// NewAdaptiveDecoder returns an [AdaptiveDecoder].
+func NewAdaptiveDecoder() *AdaptiveDecoder {
+	return &AdaptiveDecoder{}
+}
+
+// Reset prepares d to decode the data in r with [AdaptiveDecoder.ReadSymbol].
+// Decoding starts afresh, as at the beginning of the encoder's output.
+func (d *AdaptiveDecoder) Reset(r io.Reader) {
+	d.t = newAdaptiveTree()
+	d.br = newBitReader(r)
+}
+
+// ReadSymbol decodes and returns the next symbol from the reader passed to [AdaptiveDecoder.Reset].
+// At the end of the data, it returns io.EOF.
+func (d *AdaptiveDecoder) ReadSymbol() (Symbol, error) {
+	if d.br == nil {
+		return 0, errors.New("huffman.AdaptiveDecoder.ReadSymbol: no reader; call Reset")
+	}
+	if _, err := d.br.peek(); err != nil {
+		return 0, err
+	}
+	n := d.t.order[0]
+	for !n.isLeaf() {
+		b, err := d.br.readBits(1)
+		if err != nil {
+			return 0, noEOF(err)
+		}
+		n = n.child[b]
+	}
+	s := n.sym
+	if n == d.t.nyt {
+		var err error
+		s, err = d.br.readGamma()
+		if err != nil {
+			return 0, noEOF(err)
+		}
+		if d.t.leaves[s] != nil {
+			return 0, fmt.Errorf("huffman.AdaptiveDecoder: new symbol %d was seen before", s)
+		}
+	}
+	d.t.update(s)
+	return s, nil
+}
+
+// Decode decodes all the data in r, which must have been produced by an [AdaptiveEncoder].
+func (d *AdaptiveDecoder) Decode(r io.Reader) ([]Symbol, error) {
+	return decodeAll(readSymbols(r, d.Reset, d.ReadSymbol))
+}
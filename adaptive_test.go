@@ -0,0 +1,201 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestAdaptiveTreeInvariants(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, nsyms := range []int{1, 2, 3, 10, 100, 1000} {
+		tree := newAdaptiveTree()
+		counts := map[Symbol]int{}
+		for i := range 2000 {
+			// Skewed, so that weights change order often.
+			s := Symbol(r.IntN(nsyms) * r.IntN(nsyms) / max(nsyms-1, 1))
+			tree.update(s)
+			counts[s]++
+			if err := checkAdaptiveTree(tree, counts); err != nil {
+				t.Fatalf("%d symbols, after update %d (symbol %d): %v", nsyms, i, s, err)
+			}
+		}
+	}
+}
+
+// checkAdaptiveTree checks the invariants of t, and that its leaves have the given weights.
+func checkAdaptiveTree(t *adaptiveTree, counts map[Symbol]int) error {
+	if len(t.order) != 2*len(counts)+1 {
+		return fmt.Errorf("got %d nodes, want %d", len(t.order), 2*len(counts)+1)
+	}
+	if t.order[0].parent != nil {
+		return errors.New("root has a parent")
+	}
+	if t.order[len(t.order)-1] != t.nyt || t.nyt.weight != 0 || !t.nyt.isLeaf() {
+		return errors.New("NYT node is not last, or is not a zero-weight leaf")
+	}
+	for i, n := range t.order {
+		if n.pos != i {
+			return fmt.Errorf("node at %d has pos %d", i, n.pos)
+		}
+		if i > 0 && n.key() > t.order[i-1].key() {
+			return fmt.Errorf("node at %d has key %d, greater than previous %d", i, n.key(), t.order[i-1].key())
+		}
+		if i%2 == 1 && n.parent != t.order[i+1].parent {
+			return fmt.Errorf("nodes at %d and %d are not siblings", i, i+1)
+		}
+		if n.parent != nil && n.parent.child[n.index()] != n {
+			return fmt.Errorf("node at %d is not a child of its parent", i)
+		}
+		if n.isLeaf() {
+			if n != t.nyt && (t.leaves[n.sym] != n || n.weight != counts[n.sym]) {
+				return fmt.Errorf("leaf for %d has weight %d, want %d", n.sym, n.weight, counts[n.sym])
+			}
+		} else {
+			if w := n.child[0].weight + n.child[1].weight; n.weight != w {
+				return fmt.Errorf("node at %d has weight %d, want %d", i, n.weight, w)
+			}
+			if n.child[0].parent != n || n.child[1].parent != n {
+				return fmt.Errorf("children of node at %d have the wrong parent", i)
+			}
+		}
+	}
+	return nil
+}
+
+func TestAdaptiveRoundTrip(t *testing.T) {
+	pride, err := os.ReadFile(filepath.Join("testdata", "pride-and-prejudice.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		input []byte
+		split SplitFunc
+	}{
+		{"empty", nil, nil},
+		{"one", []byte("a"), nil},
+		{"repeat", bytes.Repeat([]byte("a"), 100), nil},
+		{"pride", pride, nil},
+		{"pride runes", pride, Runes(RejectInvalid).Split},
+		{"pride words", pride, Words().Split},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := NewAdaptiveEncoder(&buf, tc.split)
+			var want []Symbol
+			sp := splitter{split: tc.split}
+			if tc.split == nil {
+				for _, b := range tc.input {
+					want = append(want, Symbol(b))
+				}
+			} else {
+				emit := func(syms []Symbol) error { want = append(want, syms...); return nil }
+				if err := sp.write(tc.input, true, emit); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Write in pieces, so tokens span calls to Write.
+			for in := tc.input; len(in) > 0; in = in[min(1000, len(in)):] {
+				if _, err := enc.Write(in[:min(1000, len(in))]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if tc.name == "pride" {
+				// Adaptive coding should be nearly as good as a static code,
+				// even for this small input.
+				code, err := NewCode(byteFreqs(tc.input))
+				if err != nil {
+					t.Fatal(err)
+				}
+				bits, _ := code.EncodedBits(byteFreqs(tc.input))
+				static := bits/8 + len(code.Marshal())
+				t.Logf("adaptive: %d bytes, static: %d bytes", buf.Len(), static)
+				if float64(buf.Len()) > 1.03*float64(static) {
+					t.Errorf("adaptive: %d bytes, more than 3%% larger than static: %d bytes", buf.Len(), static)
+				}
+			}
+			got, err := NewAdaptiveDecoder().Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("got %d symbols, want %d; they differ", len(got), len(want))
+			}
+		})
+	}
+}
+
+func byteFreqs(data []byte) []int {
+	freqs := make([]int, 256)
+	for _, b := range data {
+		freqs[b]++
+	}
+	return freqs
+}
+
+func TestAdaptiveSymbols(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	var syms []Symbol
+	for range 5000 {
+		switch r.IntN(4) {
+		case 0:
+			syms = append(syms, r.Uint32())
+		case 1:
+			syms = append(syms, math.MaxUint32)
+		default:
+			syms = append(syms, Symbol(r.IntN(20)))
+		}
+	}
+	var buf bytes.Buffer
+	enc := NewAdaptiveEncoder(&buf, nil)
+	if err := enc.WriteSymbols(syms); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	dec := NewAdaptiveDecoder()
+	if _, err := dec.ReadSymbol(); err == nil {
+		t.Error("ReadSymbol before Reset: got nil, want error")
+	}
+	dec.Reset(bytes.NewReader(data))
+	for i, want := range syms {
+		got, err := dec.ReadSymbol()
+		if err != nil {
+			t.Fatalf("symbol %d: %v", i, err)
+		}
+		if got != want {
+			t.Fatalf("symbol %d: got %d, want %d", i, got, want)
+		}
+	}
+	if _, err := dec.ReadSymbol(); err != io.EOF {
+		t.Errorf("at end: got %v, want EOF", err)
+	}
+
+	// Truncated data. Since the final bits may decode to other symbols,
+	// check only that the data is not decoded successfully.
+	for _, n := range []int{2, 10, len(data) / 2} {
+		trunc := append(slices.Clip(data[:len(data)-n]), 8)
+		got, err := NewAdaptiveDecoder().Decode(bytes.NewReader(trunc))
+		if err == nil && slices.Equal(got, syms) {
+			t.Errorf("truncated by %d: decoded successfully", n)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// An Alphabet assigns Symbols to string tokens, such as words.
+// The first token added is Symbol 0, the next is Symbol 1, and so on,
+// so the symbols of an Alphabet are dense.
+//
+// Use an Alphabet to build a [Code] for tokens with [CodeBuilder.WriteTokens]
+// or [Alphabet.Tokenizer], encode tokens with [Encoder.WriteTokens], and decode
+// them with [Decoder.ReadToken]. Marshal the Alphabet together with its Code
+// with [Code.MarshalAlphabet].
+//
+// An Alphabet is not safe for concurrent use while tokens are being added.
+type Alphabet struct {
+	syms   map[string]Symbol
+	tokens []string // tokens[s] is the token for Symbol(s)
+}
+
+// NewAlphabet returns an [Alphabet] containing the given tokens, in order.
+// Duplicate tokens are ignored.
+func NewAlphabet(tokens ...string) *Alphabet {
+	a := &Alphabet{syms: map[string]Symbol{}}
+	for _, t := range tokens {
+		a.Add(t)
+	}
+	return a
+}
+
+// Add returns the symbol for tok, adding tok to the alphabet if it is not present.
+func (a *Alphabet) Add(tok string) Symbol {
+	if s, ok := a.syms[tok]; ok {
+		return s
+	}
+	s := Symbol(len(a.tokens))
+	a.tokens = append(a.tokens, tok)
+	a.syms[tok] = s
+	return s
+}
+
+// Symbol returns the symbol for tok, and reports whether tok is in the alphabet.
+func (a *Alphabet) Symbol(tok string) (Symbol, bool) {
+	s, ok := a.syms[tok]
+	return s, ok
+}
+
+// Token returns the token for s, and reports whether s is in the alphabet.
+func (a *Alphabet) Token(s Symbol) (string, bool) {
+	if int64(s) >= int64(len(a.tokens)) {
+		return "", false
+	}
+	return a.tokens[s], true
+}
+
+// Len returns the number of tokens in the alphabet.
+func (a *Alphabet) Len() int {
+	return len(a.tokens)
+}
+
+// A TokenSplitFunc splits bytes into tokens.
+// It is like a [SplitFunc], but returns tokens instead of symbols.
+type TokenSplitFunc func(data []byte, atEOF bool) (advance int, tokens []string, err error)
+
+// Tokenizer returns a [Tokenizer] that uses split to split its input into tokens,
+// and represents each token by its symbol in a.
+// Splitting adds new tokens to a.
+// Joining returns an error for a symbol that is not in a.
+func (a *Alphabet) Tokenizer(split TokenSplitFunc) Tokenizer {
+	return Tokenizer{
+		Split: func(data []byte, atEOF bool) (int, []Symbol, error) {
+			n, toks, err := split(data, atEOF)
+			syms := make([]Symbol, len(toks))
+			for i, t := range toks {
+				syms[i] = a.Add(t)
+			}
+			return n, syms, err
+		},
+		Join: func(dst []byte, syms []Symbol) ([]byte, error) {
+			for _, s := range syms {
+				t, ok := a.Token(s)
+				if !ok {
+					return dst, fmt.Errorf("huffman: symbol %d is not in the alphabet", s)
+				}
+				dst = append(dst, t...)
+			}
+			return dst, nil
+		},
+	}
+}
+
+// WriteTokens adds the symbols of toks to the sequence of symbols used to construct the [Code].
+// Tokens that are not in a are added to it.
+func (cb *CodeBuilder) WriteTokens(a *Alphabet, toks ...string) {
+	for _, t := range toks {
+		cb.count(a.Add(t))
+	}
+}
+
+// WriteTokens writes the symbols of toks in a to the encoder.
+// It stops at the first error, which is sticky like the errors of [Encoder.WriteSymbol].
+// It is an error for a token not to be in a.
+func (e *Encoder) WriteTokens(a *Alphabet, toks ...string) error {
+	for _, t := range toks {
+		if e.err != nil {
+			return e.err
+		}
+		s, ok := a.Symbol(t)
+		if !ok {
+			e.err = fmt.Errorf("huffman: token %q is not in the alphabet", t)
+			return e.err
+		}
+		if err := e.WriteSymbol(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadToken decodes the next symbol, like [Decoder.ReadSymbol],
+// and returns its token in a.
+func (d *Decoder) ReadToken(a *Alphabet) (string, error) {
+	s, err := d.ReadSymbol()
+	if err != nil {
+		return "", err
+	}
+	t, ok := a.Token(s)
+	if !ok {
+		return "", fmt.Errorf("huffman: decoded symbol %d is not in the alphabet", s)
+	}
+	return t, nil
+}
+
+// MarshalAlphabet is like [Code.Marshal], but also represents a,
+// whose symbols c encodes.
+func (c *Code) MarshalAlphabet(a *Alphabet) []byte {
+	// The format is the length of the marshaled Code as a uvarint, the marshaled Code,
+	// the number of tokens as a uvarint, then each token preceded by its length as a uvarint.
+	mc := c.Marshal()
+	buf := binary.AppendUvarint(nil, uint64(len(mc)))
+	buf = append(buf, mc...)
+	buf = binary.AppendUvarint(buf, uint64(len(a.tokens)))
+	for _, t := range a.tokens {
+		buf = binary.AppendUvarint(buf, uint64(len(t)))
+		buf = append(buf, t...)
+	}
+	return buf
+}
+
+// UnmarshalCodeAlphabet reconstructs a [Code] and [Alphabet] from data,
+// which must have been created with [Code.MarshalAlphabet].
+func UnmarshalCodeAlphabet(data []byte) (*Code, *Alphabet, error) {
+	errShort := errors.New("huffman.UnmarshalCodeAlphabet: data too short")
+	// next returns the next uvarint-prefixed byte slice.
+	next := func() ([]byte, error) {
+		n, k := binary.Uvarint(data)
+		if k <= 0 || n > uint64(len(data)-k) {
+			return nil, errShort
+		}
+		b := data[k : k+int(n)]
+		data = data[k+int(n):]
+		return b, nil
+	}
+	mc, err := next()
+	if err != nil {
+		return nil, nil, err
+	}
+	code, err := UnmarshalCode(mc)
+	if err != nil {
+		return nil, nil, err
+	}
+	ntoks, k := binary.Uvarint(data)
+	// Each token occupies at least one byte.
+	if k <= 0 || ntoks > uint64(len(data)-k) {
+		return nil, nil, errShort
+	}
+	data = data[k:]
+	a := &Alphabet{syms: make(map[string]Symbol, ntoks), tokens: make([]string, 0, ntoks)}
+	for range ntoks {
+		t, err := next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := a.syms[string(t)]; ok {
+			return nil, nil, fmt.Errorf("huffman.UnmarshalCodeAlphabet: duplicate token %q", t)
+		}
+		a.Add(string(t))
+	}
+	if len(data) > 0 {
+		return nil, nil, fmt.Errorf("huffman.UnmarshalCodeAlphabet: %d extra bytes", len(data))
+	}
+	return code, a, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAlphabet(t *testing.T) {
+	a := NewAlphabet("a", "b", "a", "")
+	if got, want := a.Len(), 3; got != want {
+		t.Fatalf("Len = %d, want %d", got, want)
+	}
+	if s := a.Add("c"); s != 3 {
+		t.Errorf("Add(c) = %d, want 3", s)
+	}
+	if s := a.Add("b"); s != 1 {
+		t.Errorf("Add(b) = %d, want 1", s)
+	}
+	if s, ok := a.Symbol(""); !ok || s != 2 {
+		t.Errorf("Symbol(\"\") = %d, %t, want 2, true", s, ok)
+	}
+	if _, ok := a.Symbol("d"); ok {
+		t.Error("Symbol(d): got true, want false")
+	}
+	if tok, ok := a.Token(3); !ok || tok != "c" {
+		t.Errorf("Token(3) = %q, %t, want c, true", tok, ok)
+	}
+	if _, ok := a.Token(4); ok {
+		t.Error("Token(4): got true, want false")
+	}
+}
+
+func TestAlphabetTokens(t *testing.T) {
+	lines := []string{
+		"GET /index.html 200",
+		"GET /favicon.ico 404",
+		"POST /login 200",
+		"GET /index.html 304",
+	}
+	a := NewAlphabet()
+	cb := NewCodeBuilder(nil)
+	for _, l := range lines {
+		cb.WriteTokens(a, strings.Fields(l)...)
+	}
+	code, err := cb.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code2, a2, err := UnmarshalCodeAlphabet(code.MarshalAlphabet(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(a2.tokens, a.tokens) {
+		t.Fatalf("got tokens %q, want %q", a2.tokens, a.tokens)
+	}
+	if !slices.Equal(code2.Lengths(), code.Lengths()) {
+		t.Fatalf("got lengths %v, want %v", code2.Lengths(), code.Lengths())
+	}
+
+	var buf bytes.Buffer
+	enc := code.NewEncoder(&buf, nil)
+	for _, l := range lines {
+		enc.WriteTokens(a, strings.Fields(l)...)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dec := code2.NewDecoder()
+	dec.Reset(&buf)
+	var got []string
+	for {
+		tok, err := dec.ReadToken(a2)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, tok)
+	}
+	want := strings.Fields(strings.Join(lines, " "))
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	enc = code.NewEncoder(io.Discard, nil)
+	enc.WriteTokens(a, "GET", "PUT")
+	if err := enc.Close(); err == nil {
+		t.Error("unknown token: got nil, want error")
+	}
+}
+
+func TestAlphabetTokenizer(t *testing.T) {
+	// Split on commas, keeping them as separate tokens.
+	split := func(data []byte, atEOF bool) (int, []string, error) {
+		var toks []string
+		start := 0
+		for i, b := range data {
+			if b == ',' {
+				toks = append(toks, string(data[start:i]), ",")
+				start = i + 1
+			}
+		}
+		if atEOF && start < len(data) {
+			toks = append(toks, string(data[start:]))
+			start = len(data)
+		}
+		return start, toks, nil
+	}
+	a := NewAlphabet()
+	testTokenizerRoundTrip(t, []byte("alpha,beta,,gamma,alpha,beta,delta"), a.Tokenizer(split))
+	if got, want := a.tokens, []string{"alpha", ",", "beta", "", "gamma", "delta"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestUnmarshalCodeAlphabetErrors(t *testing.T) {
+	code, err := NewCode([]int{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := code.MarshalAlphabet(NewAlphabet("x", "y", "z"))
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated", good[:len(good)-1]},
+		{"extra", append(slices.Clip(good), 0)},
+		{"bad_code", append([]byte{1, 0}, good[2:]...)},
+		{"duplicate", code.MarshalAlphabet(&Alphabet{tokens: []string{"x", "x"}})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := UnmarshalCodeAlphabet(tc.data); err == nil {
+				t.Error("got nil, want error")
+			}
+		})
+	}
+}
@@ -4,7 +4,12 @@
 
 package huffman
 
-import "io"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
 
 // Much of the code in this file is adapted from the standard library's compress/flate package.
 
@@ -49,6 +54,22 @@ func (w *bitWriter) writeBits(b uint32, n int) {
 	}
 }
 
+// writeGamma writes s+1 in Elias gamma coding: if s+1 has N+1 significant bits,
+// N zero bits, then a one bit, then the low N bits of s+1.
+// Unlike the usual gamma code, the low bits are written least significant first.
+func (w *bitWriter) writeGamma(s uint32) {
+	x := uint64(s) + 1
+	n := bits.Len64(x) - 1
+	w.writeBits(0, n)
+	w.writeBits(1, 1)
+	w.writeBits(lowOrderBits(uint32(x), n), n)
+}
+
+// gammaLen returns the number of bits that [bitWriter.writeGamma] writes for s.
+func gammaLen(s uint32) int {
+	return 2*(bits.Len64(uint64(s)+1)-1) + 1
+}
+
 func (w *bitWriter) Close() error {
 	// Flush remaining bits, then write a trailer byte indicating
 	// how many bits in the last data byte are valid (1-8), or 0
@@ -111,6 +132,7 @@ type bitReader struct {
 	ahead    byte // one byte of lookahead; might be the trailer
 	hasAhead bool
 	atEOF    bool
+
 	remaining int // valid bits left to read; -1 until trailer is seen
 }
 
@@ -185,9 +207,15 @@ func (r *bitReader) fill() {
 
 		// Compute remaining valid bits. We may have packed the partial
 		// last data byte as a full 8 bits; subtract the padding.
-		if trailer == 0 {
+		switch {
+		case trailer > 8:
+			r.err = fmt.Errorf("huffman: invalid trailer byte %d", trailer)
+		case trailer == 0:
 			r.remaining = 0
-		} else {
+		case r.nbits < 8-trailer:
+			// The padding has already been read.
+			r.err = io.ErrUnexpectedEOF
+		default:
 			r.remaining = r.nbits - (8 - trailer)
 		}
 	} else {
@@ -246,30 +274,95 @@ func (r *bitReader) readBits(n int) (byte, error) {
 	return byte(res), nil
 }
 
+// readBitsN reads n bits (0-32) and returns them in the low-order bits.
+func (r *bitReader) readBitsN(n int) (uint32, error) {
+	var res uint32
+	for i := 0; i < n; i += 8 {
+		b, err := r.readBits(min(8, n-i))
+		if err != nil {
+			return 0, err
+		}
+		res |= uint32(b) << i
+	}
+	return res, nil
+}
+
+// readGamma reads a value written by [bitWriter.writeGamma].
+func (r *bitReader) readGamma() (uint32, error) {
+	n := 0
+	for {
+		b, err := r.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		if b == 1 {
+			break
+		}
+		if n++; n > 32 {
+			return 0, errors.New("huffman: gamma code too long")
+		}
+	}
+	low, err := r.readBitsN(n)
+	if err != nil {
+		return 0, noEOF(err)
+	}
+	x := uint64(1)<<n | uint64(low)
+	if x-1 > 1<<32-1 {
+		return 0, errors.New("huffman: gamma code out of range")
+	}
+	return uint32(x - 1), nil
+}
+
 // peek returns the next 8 bits (or fewer at the end) without consuming them.
 func (r *bitReader) peek() (byte, error) {
+	b, err := r.peekBits(8)
+	return byte(b), err
+}
+
+// peekBits returns the next n bits (1-24), or fewer at the end, without consuming them.
+// At the end, the missing high-order bits are zero.
+// It returns io.EOF if there are no more bits.
+func (r *bitReader) peekBits(n int) (uint32, error) {
 	if r.err != nil {
 		return 0, r.err
 	}
-	if r.remaining == 0 {
-		return 0, io.EOF
-	}
-	if r.nbits < 8 {
+	// Keep at least 8 bits, so that the trailer is seen before
+	// the padding of the last data byte could be mistaken for data.
+	for r.nbits < max(n, 8) && !r.atEOF {
 		r.fill()
 		if r.err != nil {
-			if r.nbits == 0 || r.remaining == 0 {
-				return 0, r.err
-			}
-			r.err = nil
+			return 0, r.err
 		}
 	}
 	if r.remaining == 0 {
 		return 0, io.EOF
 	}
-	return byte(lowOrderBits(r.bits, 8)), nil
+	return uint32(lowOrderBits(r.bits, n)), nil
+}
+
+// skipBits consumes n bits, which must have been returned by [bitReader.peekBits].
+func (r *bitReader) skipBits(n int) error {
+	if r.remaining >= 0 && n > r.remaining || r.nbits < n {
+		return io.ErrUnexpectedEOF
+	}
+	r.nbits -= n
+	r.bits >>= n
+	if r.remaining >= 0 {
+		r.remaining -= n
+	}
+	return nil
 }
 
 // lowOrderBits returns the n low-order bits of u.
 func lowOrderBits[T uint8 | uint16 | uint32 | uint64](u T, n int) T {
 	return u & ((T(1) << n) - 1)
 }
+
+// noEOF converts io.EOF to io.ErrUnexpectedEOF, for use when
+// more data is required.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
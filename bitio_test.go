@@ -8,10 +8,12 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
 	"math/rand/v2"
 	"slices"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestWriteBits(t *testing.T) {
@@ -147,3 +149,115 @@ func TestBitRead(t *testing.T) {
 	checkRead(5, 0)
 	checkRead(3, 6)
 }
+
+func TestPeekBits(t *testing.T) {
+	// Data bytes 0x34, 0x12, 0x05 with trailer 3: 19 valid bits.
+	for _, r := range []io.Reader{
+		bytes.NewReader([]byte{0x34, 0x12, 0x05, 3}),
+		iotest.OneByteReader(bytes.NewReader([]byte{0x34, 0x12, 0x05, 3})),
+	} {
+		br := newBitReader(r)
+		check := func(n int, want uint32) {
+			t.Helper()
+			got, err := br.peekBits(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Fatalf("peekBits(%d): got %#x, want %#x", n, got, want)
+			}
+			if err := br.skipBits(n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		check(12, 0x234)
+		check(1, 1)
+		check(6, 0x28)
+		// Only padding is left.
+		if _, err := br.peekBits(1); err != io.EOF {
+			t.Fatalf("got %v, want EOF", err)
+		}
+		if err := br.skipBits(1); err != io.ErrUnexpectedEOF {
+			t.Fatalf("got %v, want unexpected EOF", err)
+		}
+	}
+
+	// Peeking past the end returns the remaining bits.
+	br := newBitReader(bytes.NewReader([]byte{0xff, 0x01, 1}))
+	got, err := br.peekBits(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 0x1ff {
+		t.Errorf("got %#x, want 0x1ff", got)
+	}
+	// Consuming the padding is an error, but it may not be detected until the trailer is read.
+	err = br.skipBits(10)
+	if err == nil {
+		_, err = br.peekBits(1)
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, want unexpected EOF", err)
+	}
+}
+
+func TestBitReadBadTrailer(t *testing.T) {
+	br := newBitReader(bytes.NewReader([]byte{1, 2, 3, 9}))
+	for {
+		_, err := br.readBits(8)
+		if err == nil {
+			continue
+		}
+		if err == io.ErrUnexpectedEOF {
+			t.Fatal("got unexpected EOF, want invalid trailer error")
+		}
+		break
+	}
+}
+
+func TestGamma(t *testing.T) {
+	vals := []uint32{0, 1, 2, 3, 6, 7, 100, 65534, 65535, 1<<31 - 1, 1 << 31, math.MaxUint32 - 1, math.MaxUint32}
+	var buf bytes.Buffer
+	bw := newBitWriter(&buf)
+	wantBits := 0
+	for _, v := range vals {
+		bw.writeGamma(v)
+		bw.writeBits(1, 1) // check alignment
+		wantBits += gammaLen(v) + 1
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.Len()-1, (wantBits+7)/8; got != want {
+		t.Errorf("wrote %d bytes, want %d", got, want)
+	}
+	br := newBitReader(&buf)
+	for _, want := range vals {
+		got, err := br.readGamma()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if b, err := br.readBits(1); err != nil || b != 1 {
+			t.Fatalf("after %d: got %d, %v; want 1, nil", want, b, err)
+		}
+	}
+	for v, want := range map[uint32]int{0: 1, 1: 3, 2: 3, 3: 5, math.MaxUint32: 65} {
+		if got := gammaLen(v); got != want {
+			t.Errorf("gammaLen(%d) = %d, want %d", v, got, want)
+		}
+	}
+
+	// Values out of range.
+	buf.Reset()
+	bw = newBitWriter(&buf)
+	bw.writeBits(0, 32)
+	bw.writeBits(1, 1)
+	bw.writeBits(1, 32)
+	bw.Close()
+	if _, err := newBitReader(&buf).readGamma(); err == nil {
+		t.Error("got nil, want error")
+	}
+}
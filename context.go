@@ -0,0 +1,315 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+)
+
+// A ContextFunc returns the context of a symbol, given the symbol before it.
+// Contexts are non-negative integers that select among the Codes of a [ContextCode].
+// The first symbol of the input is always in context 0.
+//
+// A nil ContextFunc uses the previous symbol as the context.
+type ContextFunc func(prev Symbol) int
+
+func (f ContextFunc) context(prev Symbol) int {
+	if f == nil {
+		return int(prev)
+	}
+	return f(prev)
+}
+
+// A ContextCode holds one [Code] for each context. It encodes each symbol
+// with the Code for the symbol's context, which is determined by the previous symbol.
+// Encoding in context can be much more compact than encoding with a single Code,
+// as when the input is text.
+type ContextCode struct {
+	codes map[int]*Code // keyed by context; only contexts that occurred have a Code
+	ctx   ContextFunc
+}
+
+// Len returns the number of contexts in cc that have a Code.
+func (cc *ContextCode) Len() int {
+	return len(cc.codes)
+}
+
+// Code returns the Code for the given context, or nil if there is none.
+func (cc *ContextCode) Code(ctx int) *Code {
+	return cc.codes[ctx]
+}
+
+// A ContextCodeBuilder builds a [ContextCode] from a sequence of bytes,
+// by counting the frequencies of symbols in each context.
+// Use it like a [CodeBuilder].
+type ContextCodeBuilder struct {
+	ctx   ContextFunc
+	sp    *splitter            // nil if there is no SplitFunc
+	cbs   map[int]*CodeBuilder // keyed by context
+	prev  Symbol
+	start bool // no symbols have been seen
+}
+
+// NewContextCodeBuilder constructs a [ContextCodeBuilder] that uses ctx to
+// determine contexts.
+// If split is nil, each byte of the input is a separate symbol.
+// Otherwise, split is called to split the input bytes into symbols.
+func NewContextCodeBuilder(ctx ContextFunc, split SplitFunc) *ContextCodeBuilder {
+	cb := &ContextCodeBuilder{ctx: ctx, cbs: map[int]*CodeBuilder{}, start: true}
+	if split != nil {
+		cb.sp = &splitter{split: split}
+	}
+	return cb
+}
+
+// Write adds the data to the sequence of symbols used to construct the [ContextCode].
+// It returns an error if the SplitFunc does, or if the ContextFunc returns
+// a negative context.
+func (cb *ContextCodeBuilder) Write(data []byte) (int, error) {
+	if cb.sp != nil {
+		if err := cb.sp.write(data, false, cb.WriteSymbols); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	for i, b := range data {
+		if err := cb.WriteSymbols([]Symbol{Symbol(b)}); err != nil {
+			return i, err
+		}
+	}
+	return len(data), nil
+}
+
+// WriteSymbols adds syms to the sequence of symbols used to construct the [ContextCode].
+func (cb *ContextCodeBuilder) WriteSymbols(syms []Symbol) error {
+	for _, s := range syms {
+		ctx := 0
+		if !cb.start {
+			ctx = cb.ctx.context(cb.prev)
+		}
+		if ctx < 0 {
+			return fmt.Errorf("huffman: negative context %d", ctx)
+		}
+		b := cb.cbs[ctx]
+		if b == nil {
+			b = &CodeBuilder{}
+			cb.cbs[ctx] = b
+		}
+		b.addSymbols([]Symbol{s})
+		cb.prev = s
+		cb.start = false
+	}
+	return nil
+}
+
+// Code returns the constructed [ContextCode].
+// It first passes any unconsumed bytes to the SplitFunc, with atEOF set to true.
+func (cb *ContextCodeBuilder) Code() (*ContextCode, error) {
+	return cb.CodeWithOptions(CodeOptions{})
+}
+
+// CodeWithOptions is like [ContextCodeBuilder.Code], but uses opts to construct
+// the Code for each context.
+func (cb *ContextCodeBuilder) CodeWithOptions(opts CodeOptions) (*ContextCode, error) {
+	if cb.sp != nil {
+		if err := cb.sp.write(nil, true, cb.WriteSymbols); err != nil {
+			return nil, err
+		}
+	}
+	cc := &ContextCode{codes: make(map[int]*Code, len(cb.cbs)), ctx: cb.ctx}
+	for i, b := range cb.cbs {
+		c, err := b.CodeWithOptions(opts)
+		if err != nil {
+			return nil, fmt.Errorf("context %d: %w", i, err)
+		}
+		cc.codes[i] = c
+	}
+	return cc, nil
+}
+
+// Marshal compactly represents the ContextCode as a sequence of bytes.
+// The ContextFunc is not represented.
+func (cc *ContextCode) Marshal() []byte {
+	// The number of contexts with Codes as a uvarint, then for each such context
+	// in increasing order: the context as a uvarint, encoded as the difference
+	// from the previous context minus one (the first is encoded as itself);
+	// the length of its marshaled Code as a uvarint; and the marshaled Code.
+	buf := binary.AppendUvarint(nil, uint64(len(cc.codes)))
+	prev := -1
+	for _, ctx := range slices.Sorted(maps.Keys(cc.codes)) {
+		mc := cc.codes[ctx].Marshal()
+		buf = binary.AppendUvarint(buf, uint64(ctx-prev-1))
+		buf = binary.AppendUvarint(buf, uint64(len(mc)))
+		buf = append(buf, mc...)
+		prev = ctx
+	}
+	return buf
+}
+
+// UnmarshalContextCode reconstructs a [ContextCode] from data, which must have been
+// created with [ContextCode.Marshal]. The ContextFunc ctx must be the one used
+// to build the original ContextCode.
+func UnmarshalContextCode(data []byte, ctx ContextFunc) (*ContextCode, error) {
+	errShort := errors.New("huffman.UnmarshalContextCode: data too short")
+	n, k := binary.Uvarint(data)
+	// Each context occupies at least one byte.
+	if k <= 0 || n > uint64(len(data)-k) {
+		return nil, errShort
+	}
+	data = data[k:]
+	cc := &ContextCode{codes: make(map[int]*Code, n), ctx: ctx}
+	next := uint64(0) // the smallest context that may come next
+	for range n {
+		gap, k := binary.Uvarint(data)
+		if k <= 0 {
+			return nil, errShort
+		}
+		data = data[k:]
+		if next > math.MaxInt || gap > math.MaxInt-next {
+			return nil, errors.New("huffman.UnmarshalContextCode: context out of range")
+		}
+		i := int(next + gap)
+		m, k := binary.Uvarint(data)
+		if k <= 0 || m > uint64(len(data)-k) {
+			return nil, errShort
+		}
+		data = data[k:]
+		c, err := UnmarshalCode(data[:m])
+		if err != nil {
+			return nil, fmt.Errorf("context %d: %w", i, err)
+		}
+		cc.codes[i] = c
+		data = data[m:]
+		next = uint64(i) + 1
+	}
+	if len(data) > 0 {
+		return nil, fmt.Errorf("huffman.UnmarshalContextCode: %d extra bytes", len(data))
+	}
+	return cc, nil
+}
+
+// emptyCode has no codes. Encoding a symbol with it fails.
+var emptyCode = &Code{tables: &tableCache{}}
+
+// A ContextEncoder encodes symbols with a [ContextCode].
+// Use it like an [Encoder].
+// Writing a symbol that has no code in its context is an error,
+// unless the context's Code has an escape code.
+type ContextEncoder struct {
+	cc    *ContextCode
+	enc   *Encoder // its Code is set for each symbol
+	sp    *splitter
+	prev  Symbol
+	start bool
+}
+
+// NewEncoder returns a [ContextEncoder] for cc that writes to w.
+// If split is nil, each byte written with [ContextEncoder.Write] is a symbol.
+func (cc *ContextCode) NewEncoder(w io.Writer, split SplitFunc) *ContextEncoder {
+	e := &ContextEncoder{cc: cc, enc: emptyCode.NewEncoder(w, nil), start: true}
+	if split != nil {
+		e.sp = &splitter{split: split}
+	}
+	return e
+}
+
+// Write encodes data, like [Encoder.Write].
+func (e *ContextEncoder) Write(data []byte) (int, error) {
+	if e.sp == nil {
+		return writeBytes(data, e.enc.bw, e.WriteSymbol)
+	}
+	return writeSplit(data, e.sp, &e.enc.err, e.enc.bw, e.WriteSymbols)
+}
+
+// WriteSymbol writes a symbol to the encoder, like [Encoder.WriteSymbol].
+func (e *ContextEncoder) WriteSymbol(s Symbol) error {
+	ctx := 0
+	if !e.start {
+		ctx = e.cc.ctx.context(e.prev)
+	}
+	e.enc.c = e.cc.Code(ctx)
+	if e.enc.c == nil {
+		e.enc.c = emptyCode
+	}
+	if err := e.enc.WriteSymbol(s); err != nil {
+		return err
+	}
+	e.prev = s
+	e.start = false
+	return nil
+}
+
+// WriteSymbols calls [ContextEncoder.WriteSymbol] for each symbol.
+func (e *ContextEncoder) WriteSymbols(syms []Symbol) error {
+	return writeSymbols(syms, e.WriteSymbol)
+}
+
+// Close passes any unconsumed bytes to the SplitFunc, with atEOF set to true,
+// and writes remaining data to the encoder's writer.
+func (e *ContextEncoder) Close() error {
+	return closeEncoder(e.sp, &e.enc.err, e.enc.bw, e.WriteSymbols)
+}
+
+// A ContextDecoder decodes data encoded by a [ContextEncoder].
+// Use it like a [Decoder].
+type ContextDecoder struct {
+	cc    *ContextCode
+	dec   *Decoder
+	prev  Symbol
+	start bool
+}
+
+// NewDecoder returns a [ContextDecoder] for cc.
+func (cc *ContextCode) NewDecoder() *ContextDecoder {
+	return &ContextDecoder{
+		cc:  cc,
+		dec: &Decoder{},
+	}
+}
+
+// Reset prepares d to decode the data in r with [ContextDecoder.ReadSymbol].
+func (d *ContextDecoder) Reset(r io.Reader) {
+	d.dec.Reset(r)
+	d.start = true
+}
+
+// ReadSymbol decodes and returns the next symbol from the reader passed to [ContextDecoder.Reset].
+// At the end of the data, it returns io.EOF.
+func (d *ContextDecoder) ReadSymbol() (Symbol, error) {
+	if d.dec.br == nil {
+		return 0, errors.New("huffman.ContextDecoder.ReadSymbol: no reader; call Reset")
+	}
+	ctx := 0
+	if !d.start {
+		ctx = d.cc.ctx.context(d.prev)
+	}
+	c := d.cc.Code(ctx)
+	if c == nil {
+		// There may be no more data.
+		if _, err := d.dec.br.peek(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("huffman.ContextDecoder: no code for context %d", ctx)
+	}
+	d.dec.table = c.decodingTable(0)
+	s, err := d.dec.ReadSymbol()
+	if err != nil {
+		return 0, err
+	}
+	d.prev = s
+	d.start = false
+	return s, nil
+}
+
+// Decode decodes all the data in r, which must have been produced by a [ContextEncoder].
+func (d *ContextDecoder) Decode(r io.Reader) ([]Symbol, error) {
+	return decodeAll(readSymbols(r, d.Reset, d.ReadSymbol))
+}
@@ -0,0 +1,247 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestContextCodeRoundTrip(t *testing.T) {
+	pride, err := os.ReadFile(filepath.Join("testdata", "pride-and-prejudice.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []Symbol
+	for _, b := range pride {
+		want = append(want, Symbol(b))
+	}
+	// classes puts bytes into three contexts.
+	classes := func(prev Symbol) int {
+		switch {
+		case prev == ' ' || prev == '\n':
+			return 0
+		case prev >= 'a' && prev <= 'z':
+			return 1
+		default:
+			return 2
+		}
+	}
+	code, err := NewCode(byteFreqs(pride))
+	if err != nil {
+		t.Fatal(err)
+	}
+	order0, _ := code.EncodedBits(byteFreqs(pride))
+	for _, tc := range []struct {
+		name string
+		ctx  ContextFunc
+	}{
+		{"previous", nil},
+		{"classes", classes},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cb := NewContextCodeBuilder(tc.ctx, nil)
+			if _, err := cb.Write(pride); err != nil {
+				t.Fatal(err)
+			}
+			cc, err := cb.Code()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc, err = UnmarshalContextCode(cc.Marshal(), tc.ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			enc := cc.NewEncoder(&buf, nil)
+			if _, err := enc.Write(pride); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			t.Logf("%d contexts, %d bytes of code, %d bytes of data; order-0: %d bytes",
+				cc.Len(), len(cc.Marshal()), buf.Len(), order0/8)
+			if buf.Len() >= order0/8 {
+				t.Errorf("context coding is no better than order-0")
+			}
+			got, err := cc.NewDecoder().Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, want) {
+				t.Error("decoded data differs")
+			}
+		})
+	}
+}
+
+func TestContextCodeSymbols(t *testing.T) {
+	// Runes, where the context is whether the previous rune is ASCII.
+	ascii := func(prev Symbol) int {
+		if prev < 128 {
+			return 0
+		}
+		return 1
+	}
+	input := "naïve café, déjà vu"
+	cb := NewContextCodeBuilder(ascii, Runes(RejectInvalid).Split)
+	// Write a rune at a time, in two pieces.
+	for i := 0; i < len(input); i++ {
+		if _, err := io.WriteString(cb, input[i:i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cc, err := cb.CodeWithOptions(CodeOptions{Escape: EscapeFixed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.Len() != 2 || cc.Code(0) == nil || cc.Code(1) == nil || cc.Code(2) != nil {
+		t.Fatalf("wrong contexts")
+	}
+	var buf bytes.Buffer
+	enc := cc.NewEncoder(&buf, Runes(RejectInvalid).Split)
+	// 'x' has no code in any context, but can be escaped.
+	io.WriteString(enc, input+"x")
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dec := cc.NewDecoder()
+	if _, err := dec.ReadSymbol(); err == nil {
+		t.Error("ReadSymbol before Reset: got nil, want error")
+	}
+	dec.Reset(&buf)
+	var got []rune
+	for {
+		s, err := dec.ReadSymbol()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, rune(s))
+	}
+	if want := input + "x"; string(got) != want {
+		t.Errorf("got %q, want %q", string(got), want)
+	}
+}
+
+func TestContextCodeLargeContexts(t *testing.T) {
+	// With a nil ContextFunc, each symbol is the context of the next,
+	// so the number of contexts must not depend on the size of the symbols.
+	syms := []Symbol{math.MaxUint32, 1, math.MaxUint32, 1 << 30, math.MaxUint32}
+	cb := NewContextCodeBuilder(nil, nil)
+	if err := cb.WriteSymbols(syms); err != nil {
+		t.Fatal(err)
+	}
+	cc, err := cb.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cc.Len(), 4; got != want {
+		t.Fatalf("got %d contexts, want %d", got, want)
+	}
+	cc, err = UnmarshalContextCode(cc.Marshal(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.Len() != 4 || cc.Code(math.MaxUint32) == nil || cc.Code(2) != nil {
+		t.Fatal("wrong contexts after unmarshaling")
+	}
+	var buf bytes.Buffer
+	enc := cc.NewEncoder(&buf, nil)
+	if err := enc.WriteSymbols(syms); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := cc.NewDecoder().Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, syms) {
+		t.Errorf("got %v, want %v", got, syms)
+	}
+}
+
+func TestContextCodeErrors(t *testing.T) {
+	cb := NewContextCodeBuilder(nil, nil)
+	io.WriteString(cb, "abab")
+	cc, err := cb.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Contexts 0 and 'a' have codes for "a"; context 'b' does not have a code for 'b'.
+	enc := cc.NewEncoder(io.Discard, nil)
+	var serr *SymbolError
+	if _, err := io.WriteString(enc, "abb"); !errors.As(err, &serr) || serr.Symbol != 'b' || serr.Pos != 2 {
+		t.Errorf("got %v, want SymbolError for 'b' at 2", err)
+	}
+	// Context 'c' has no code at all.
+	enc = cc.NewEncoder(io.Discard, nil)
+	if err := enc.WriteSymbols([]Symbol{'a', 'b', 'a', 'b', 'c', 'a'}); !errors.As(err, &serr) || serr.Symbol != 'c' {
+		t.Errorf("got %v, want SymbolError for 'c'", err)
+	}
+
+	cb = NewContextCodeBuilder(func(Symbol) int { return -1 }, nil)
+	if _, err := io.WriteString(cb, "ab"); err == nil {
+		t.Error("negative context: got nil, want error")
+	}
+
+	good := cc.Marshal()
+	// Two contexts: math.MaxInt, then one after it.
+	mc := cc.Code(0).Marshal()
+	afterMaxInt := binary.AppendUvarint([]byte{2}, math.MaxInt)
+	afterMaxInt = append(binary.AppendUvarint(afterMaxInt, uint64(len(mc))), mc...)
+	afterMaxInt = append(afterMaxInt, 5)
+	afterMaxInt = append(binary.AppendUvarint(afterMaxInt, uint64(len(mc))), mc...)
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated", good[:len(good)-1]},
+		{"extra", append(slices.Clip(good), 0)},
+		{"too many contexts", []byte{5, 0}},
+		{"bad code", []byte{1, 0, 1, 0}},
+		{"context out of range", []byte{1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 1, 0}},
+		{"context after MaxInt", afterMaxInt},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := UnmarshalContextCode(tc.data, nil); err == nil {
+				t.Error("got nil, want error")
+			}
+		})
+	}
+
+	// Decoding an invalid code: 'a' in context 0, then a bit that is
+	// not a code in context 'a'.
+	var buf bytes.Buffer
+	bw := newBitWriter(&buf)
+	bw.writeBits(0b10, 2)
+	bw.Close()
+	if _, err := cc.NewDecoder().Decode(&buf); err == nil {
+		t.Error("invalid code: got nil, want error")
+	}
+
+	// Decoding in a context with no code.
+	cc = &ContextCode{codes: map[int]*Code{0: cc.Code(0)}, ctx: func(Symbol) int { return 1 }}
+	buf.Reset()
+	bw = newBitWriter(&buf)
+	bw.writeBits(0, 2) // 'a' in context 0, then a bit in context 1
+	bw.Close()
+	if _, err := cc.NewDecoder().Decode(&buf); err == nil {
+		t.Error("no code: got nil, want error")
+	}
+}
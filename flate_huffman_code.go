@@ -6,30 +6,23 @@
 // That file is Copyright 2009 The Go Authors. All rights reserved.
 package huffman
 
-// TODO: support code lengths greater than 15.
-
 import (
 	"math"
 	"math/bits"
 	"sort"
 )
 
-// hcode is a huffman code with a bit code and bit length.
-type hcode struct {
-	code, len uint16
-}
-
 type huffmanEncoder struct {
-	codes     []hcode
+	codes     []bitcode
 	freqcache []literalNode
-	bitCount  [17]int32
+	bitCount  [maxBitsLimit]int32
 	lns       byLiteral // stored to avoid repeated allocation in generate
 	lfs       byFreq    // stored to avoid repeated allocation in generate
 }
 
 type literalNode struct {
-	literal uint16
-	freq    int32
+	literal uint32
+	freq    int
 }
 
 // A levelInfo describes the state of the constructed tree for a given depth.
@@ -38,43 +31,37 @@ type levelInfo struct {
 	level int32
 
 	// The frequency of the last node at this level
-	lastFreq int32
+	lastFreq int
 
 	// The frequency of the next character to add to this level
-	nextCharFreq int32
+	nextCharFreq int
 
 	// The frequency of the next pair (from level below) to add to this level.
 	// Only valid if the "needed" value of the next lower level is 0.
-	nextPairFreq int32
+	nextPairFreq int
 
 	// The number of chains remaining to generate for this level before moving
 	// up to the next level
 	needed int32
 }
 
-// set sets the code and length of an hcode.
-func (h *hcode) set(code uint16, length uint16) {
-	h.len = length
-	h.code = code
-}
-
-func maxNode() literalNode { return literalNode{math.MaxUint16, math.MaxInt32} }
+func maxNode() literalNode { return literalNode{math.MaxUint32, math.MaxInt} }
 
 func newHuffmanEncoder(size int) *huffmanEncoder {
-	return &huffmanEncoder{codes: make([]hcode, size)}
+	return &huffmanEncoder{codes: make([]bitcode, size)}
 }
 
-func (h *huffmanEncoder) bitLength(freq []int32) int {
+func (h *huffmanEncoder) bitLength(freq []int) int {
 	var total int
 	for i, f := range freq {
 		if f != 0 {
-			total += int(f) * int(h.codes[i].len)
+			total += f * int(h.codes[i].len)
 		}
 	}
 	return total
 }
 
-const maxBitsLimit = 16
+const maxBitsLimit = maxCodeLen + 1
 
 // bitCounts computes the number of literals assigned to each bit size in the Huffman encoding.
 // It is only called when list.length >= 3.
@@ -83,16 +70,16 @@ const maxBitsLimit = 16
 // list is an array of the literals with non-zero frequencies
 // and their associated frequencies. The array is in order of increasing
 // frequency and has as its last element a special element with frequency
-// MaxInt32.
+// MaxInt.
 //
 // maxBits is the maximum number of bits that should be used to encode any literal.
-// It must be less than 16.
+// It must be at most maxCodeLen, and list.length must not exceed 2^maxBits.
 //
 // bitCounts returns an integer slice in which slice[i] indicates the number of literals
 // that should be encoded in i bits.
 func (h *huffmanEncoder) bitCounts(list []literalNode, maxBits int32) []int32 {
 	if maxBits >= maxBitsLimit {
-		panic("flate: maxBits too large")
+		panic("huffman: maxBits too large")
 	}
 	n := int32(len(list))
 	list = list[0 : n+1]
@@ -126,7 +113,7 @@ func (h *huffmanEncoder) bitCounts(list []literalNode, maxBits int32) []int32 {
 		}
 		leafCounts[level][level] = 2
 		if level == 1 {
-			levels[level].nextPairFreq = math.MaxInt32
+			levels[level].nextPairFreq = math.MaxInt
 		}
 	}
 
@@ -136,13 +123,13 @@ func (h *huffmanEncoder) bitCounts(list []literalNode, maxBits int32) []int32 {
 	level := maxBits
 	for {
 		l := &levels[level]
-		if l.nextPairFreq == math.MaxInt32 && l.nextCharFreq == math.MaxInt32 {
+		if l.nextPairFreq == math.MaxInt && l.nextCharFreq == math.MaxInt {
 			// We've run out of both leaves and pairs.
 			// End all calculations for this level.
 			// To make sure we never come back to this level or any lower level,
 			// set nextPairFreq impossibly large.
 			l.needed = 0
-			levels[level+1].nextPairFreq = math.MaxInt32
+			levels[level+1].nextPairFreq = math.MaxInt
 			level++
 			continue
 		}
@@ -205,7 +192,7 @@ func (h *huffmanEncoder) bitCounts(list []literalNode, maxBits int32) []int32 {
 // Look at the leaves and assign them a bit count and an encoding as specified
 // in RFC 1951 3.2.2
 func (h *huffmanEncoder) assignEncodingAndSize(bitCount []int32, list []literalNode) {
-	code := uint16(0)
+	code := uint32(0)
 	for n, bits := range bitCount {
 		code <<= 1
 		if n == 0 || bits == 0 {
@@ -219,7 +206,7 @@ func (h *huffmanEncoder) assignEncodingAndSize(bitCount []int32, list []literalN
 
 		h.lns.sort(chunk)
 		for _, node := range chunk {
-			h.codes[node.literal] = hcode{code: reverseBits(code, uint8(n)), len: uint16(n)}
+			h.codes[node.literal] = bitcode{val: reverseBits(code, uint8(n)), len: uint32(n)}
 			code++
 		}
 		list = list[0 : len(list)-int(bits)]
@@ -230,7 +217,7 @@ func (h *huffmanEncoder) assignEncodingAndSize(bitCount []int32, list []literalN
 //
 // freq is an array of frequencies, in which freq[i] gives the frequency of literal i.
 // maxBits  The maximum number of bits to use for any literal.
-func (h *huffmanEncoder) generate(freq []int32, maxBits int32) {
+func (h *huffmanEncoder) generate(freq []int, maxBits int32) {
 	n := len(freq) + 1
 	if len(h.freqcache) < n {
 		h.freqcache = make([]literalNode, n)
@@ -241,7 +228,7 @@ func (h *huffmanEncoder) generate(freq []int32, maxBits int32) {
 	// Set list to be the set of all non-zero literals and their frequencies
 	for i, f := range freq {
 		if f != 0 {
-			list[count] = literalNode{uint16(i), f}
+			list[count] = literalNode{uint32(i), f}
 			count++
 		} else {
 			h.codes[i].len = 0
@@ -254,7 +241,7 @@ func (h *huffmanEncoder) generate(freq []int32, maxBits int32) {
 		// two or fewer literals, everything has bit length 1.
 		for i, node := range list {
 			// "list" is in order of increasing literal value.
-			h.codes[node.literal].set(uint16(i), 1)
+			h.codes[node.literal] = bitcode{val: uint32(i), len: 1}
 		}
 		return
 	}
@@ -299,6 +286,6 @@ func (s byFreq) Less(i, j int) bool {
 
 func (s byFreq) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 
-func reverseBits(number uint16, bitLength byte) uint16 {
-	return bits.Reverse16(number << (16 - bitLength))
+func reverseBits(number uint32, bitLength byte) uint32 {
+	return bits.Reverse32(number << (32 - bitLength))
 }
@@ -12,18 +12,36 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"maps"
+	"math"
 	"slices"
+	"sync"
 )
 
 // A Symbol is a symbol in an alphabet. It may represent a byte or Unicode code point,
-// or it may be an index into a table of arbitrary runes or strings.
+// or it may be an index into a table of arbitrary runes or strings, such as an [Alphabet].
 // For the most part, this package does not distinguish those cases. The exception
-// is [Encoder.AddBytes], which expects the symbols to be bytes.
+// is [Encoder.WriteBytes], which expects the symbols to be bytes.
 type Symbol = uint32
 
 // A Code is a mapping from Symbols to bit sequences.
+//
+// A Code is either dense or sparse. A dense Code's memory is proportional
+// to its largest Symbol; a sparse Code's is proportional to the number of
+// Symbols that have codes. See [NewSparseCode].
+//
+// A Code is immutable once constructed, and is safe for concurrent use
+// by multiple goroutines. (The exceptions are [Code.UnmarshalBinary] and
+// [Code.UnmarshalText], which replace the Code.) The table used for decoding
+// is built the first time a [Decoder] needs it, and is shared by all Decoders
+// for the Code.
 type Code struct {
-	codes []bitcode
+	codes   []bitcode
+	syms    []Symbol    // if non-nil, the Code is sparse: codes[i] is the code for syms[i], and syms is sorted
+	esc     bitcode     // the escape code, if escMode != NoEscape
+	escMode EscapeMode  // how symbols without a code are written
+	tables  *tableCache // nil if the Code was not made by a constructor
 }
 
 type bitcode struct {
@@ -32,116 +50,259 @@ type bitcode struct {
 
 const maxCodeLen = 20
 
+// maxSymbols is the maximum number of symbols in a Code's alphabet.
+const maxSymbols = 1 << 32
+
+// CodeOptions are options for constructing a [Code].
+type CodeOptions struct {
+	// MaxLen is the maximum length of a code, in bits.
+	// It must be between 1 and 20. If zero, 20 is used.
+	// A Code for n symbols requires a MaxLen of at least log2(n).
+	MaxLen int
+
+	// AllowIncomplete permits [NewCodeFromLengthsWithOptions] to construct
+	// a Code whose lengths do not use every possible bit sequence.
+	// Some bit sequences will then not decode to any symbol.
+	AllowIncomplete bool
+
+	// Escape reserves an escape code for symbols that have no code of their own,
+	// and says how those symbols are written after it.
+	// With an escape code, an [Encoder] can write any Symbol.
+	Escape EscapeMode
+}
+
+// An EscapeMode describes how a [Code] writes symbols that have no code of their own.
+type EscapeMode int
+
+const (
+	// NoEscape means the Code has no escape code. Only symbols with codes
+	// can be written.
+	NoEscape EscapeMode = iota
+
+	// EscapeFixed writes the escape code followed by the symbol in 32 bits.
+	EscapeFixed
+
+	// EscapeGamma writes the escape code followed by the symbol plus one
+	// in Elias gamma coding, which takes 2*floor(log2(s+1))+1 bits for symbol s.
+	// It is shorter than EscapeFixed for symbols less than 65535.
+	EscapeGamma
+)
+
+func (m EscapeMode) String() string {
+	switch m {
+	case NoEscape:
+		return "NoEscape"
+	case EscapeFixed:
+		return "EscapeFixed"
+	case EscapeGamma:
+		return "EscapeGamma"
+	default:
+		return fmt.Sprintf("EscapeMode(%d)", int(m))
+	}
+}
+
 // NewCode constructs a [Code] for symbols with the given frequencies.
 // The value at frequencies[i] is the frequency for Symbol(i).
 // If a frequency is 0, the corresponding symbol must not appear
-// in the input given to an [Encoder].
+// in the input given to an [Encoder], unless the Code has an escape
+// code (see [CodeOptions.Escape]).
 func NewCode(frequencies []int) (*Code, error) {
-	if len(frequencies) > 1<<maxCodeLen {
-		return nil, fmt.Errorf("huffman.NewCode: too many frequencies (max 2^%d)", maxCodeLen)
+	return NewCodeWithOptions(frequencies, CodeOptions{})
+}
+
+// NewCodeWithOptions is like [NewCode], but takes options.
+// If opts.Escape is set, the escape code is constructed as if it were
+// a symbol with frequency 1.
+func NewCodeWithOptions(frequencies []int, opts CodeOptions) (*Code, error) {
+	if opts.Escape < NoEscape || opts.Escape > EscapeGamma {
+		return nil, fmt.Errorf("huffman.NewCode: invalid %s", opts.Escape)
+	}
+	maxBits := opts.MaxLen
+	if maxBits == 0 {
+		maxBits = maxCodeLen
+	}
+	if maxBits < 1 || maxBits > maxCodeLen {
+		return nil, fmt.Errorf("huffman.NewCode: MaxLen %d out of range 1-%d", maxBits, maxCodeLen)
 	}
+	if uint64(len(frequencies)) > maxSymbols {
+		return nil, errors.New("huffman.NewCode: too many frequencies (max 2^32)")
+	}
+	nonzero, total := 0, 0
 	for _, f := range frequencies {
 		if f < 0 {
 			return nil, errors.New("huffman.NewCode: negative frequency")
 		}
+		if f > 0 {
+			nonzero++
+		}
+		// The code construction adds frequencies, so their sum must not overflow.
+		if total += f; total < 0 {
+			return nil, errors.New("huffman.NewCode: sum of frequencies is too large")
+		}
 	}
-	enc := newHuffmanEncoder(len(frequencies))
-	freqs := make([]int32, len(frequencies))
-	for i, f := range frequencies {
-		freqs[i] = int32(f)
+	if opts.Escape != NoEscape {
+		if total == math.MaxInt {
+			return nil, errors.New("huffman.NewCode: sum of frequencies is too large")
+		}
+		// The escape code is the last symbol.
+		frequencies = append(slices.Clip(frequencies), 1)
+		nonzero++
 	}
-	enc.generate(freqs, 15)
-	c := &Code{codes: make([]bitcode, len(enc.codes))}
-	for i, hc := range enc.codes {
-		c.codes[i] = bitcode{val: uint32(hc.code), len: uint32(hc.len)}
+	if nonzero > 1<<maxBits {
+		return nil, fmt.Errorf("huffman.NewCode: %d symbols with nonzero frequencies do not fit in codes of at most %d bits",
+			nonzero, maxBits)
 	}
+	enc := newHuffmanEncoder(len(frequencies))
+	enc.generate(frequencies, int32(maxBits))
+	c := &Code{codes: enc.codes, tables: &tableCache{}}
+	c.splitEscape(opts.Escape)
 	return c, nil
 }
 
-const marshalVersion = 0
-
-// Marshal compactly represents the Code as a sequence of bytes.
-func (c *Code) Marshal() []byte {
-	// Encode the lengths of the bitcodes, in order.
-	// We may eventually use an algorithm like RFC 1951, but with a larger alphabet to handle larger code sizes.
-	// For now we do something simpler, and byte-oriented.
-	// First byte: version number, with the top two bits 1's as a tiny magic header.
-	// Other bytes:
-	// There are three formats:
-	//   RRRRRRR0:  length 0, with 7 bits of repeat (1-128)
-	//   RRLLLL01:  lengths 1-16, with 2 bits of repeat (1-4)
-	//   RRRRLL11:  lengths 17-20, with 4 bits of repeat (1-16)
+// splitEscape removes the escape code from the end of c.codes, if mode is not NoEscape.
+func (c *Code) splitEscape(mode EscapeMode) {
+	c.escMode = mode
+	if mode != NoEscape {
+		n := len(c.codes) - 1
+		c.esc = c.codes[n]
+		c.codes = c.codes[:n]
+	}
+}
 
-	buf := []byte{0b11<<6 | marshalVersion}
+// NewCodeFromLengths constructs a canonical [Code] from code lengths.
+// The value at lengths[i] is the length in bits of the code for Symbol(i),
+// or 0 if the symbol has no code. The codes are assigned as described
+// in RFC 1951, section 3.2.2.
+//
+// The lengths must describe a complete prefix code: one in which
+// every bit sequence begins with some code. As a special case, a
+// single code of length 1 is permitted, as constructed by [NewCode]
+// for a single symbol.
+func NewCodeFromLengths(lengths []uint8) (*Code, error) {
+	return NewCodeFromLengthsWithOptions(lengths, CodeOptions{})
+}
 
-	rep := func(R, len int, bottom byte) {
-		shift := 8 - len
-		max := 1 << len
-		for R >= max {
-			buf = append(buf, byte((max-1)<<shift)|bottom)
-			R -= max
-		}
-		if R > 0 {
-			buf = append(buf, byte((R-1)<<shift)|bottom)
+// NewCodeFromLengthsWithOptions is like [NewCodeFromLengths], but takes options.
+// It is an error if a length exceeds opts.MaxLen.
+// If opts.AllowIncomplete is true, the lengths need not describe a complete code.
+// If opts.Escape is set, the last length is the length of the escape code,
+// and must not be zero.
+func NewCodeFromLengthsWithOptions(lengths []uint8, opts CodeOptions) (*Code, error) {
+	if opts.Escape < NoEscape || opts.Escape > EscapeGamma {
+		return nil, fmt.Errorf("huffman.NewCodeFromLengths: invalid %s", opts.Escape)
+	}
+	if opts.Escape != NoEscape && (len(lengths) == 0 || lengths[len(lengths)-1] == 0) {
+		return nil, errors.New("huffman.NewCodeFromLengths: missing escape code length")
+	}
+	maxBits := opts.MaxLen
+	if maxBits == 0 {
+		maxBits = maxCodeLen
+	}
+	if maxBits < 1 || maxBits > maxCodeLen {
+		return nil, fmt.Errorf("huffman.NewCodeFromLengths: MaxLen %d out of range 1-%d", maxBits, maxCodeLen)
+	}
+	if uint64(len(lengths)) > maxSymbols {
+		return nil, errors.New("huffman.NewCodeFromLengths: too many lengths (max 2^32)")
+	}
+	codes := make([]bitcode, len(lengths))
+	for i, l := range lengths {
+		if int(l) > maxBits {
+			return nil, fmt.Errorf("huffman.NewCodeFromLengths: length %d of symbol %d exceeds %d", l, i, maxBits)
 		}
+		codes[i].len = uint32(l)
 	}
+	if err := checkLengths(codes, opts.AllowIncomplete); err != nil {
+		return nil, fmt.Errorf("huffman.NewCodeFromLengths: %w", err)
+	}
+	assignValues(codes)
+	c := &Code{codes: codes, tables: &tableCache{}}
+	c.splitEscape(opts.Escape)
+	return c, nil
+}
 
-	i := 0
-	for i < len(c.codes) {
-		L := c.codes[i].len
-		var j int
-		for j = i + 1; j < len(c.codes) && c.codes[j].len == L; j++ {
+// checkLengths checks that the lengths of codes, each at most maxCodeLen,
+// satisfy the Kraft inequality, so that they can be assigned values that
+// form a prefix code.
+// Unless allowIncomplete is true, the code must also be complete,
+// except that a single code of length 1 is allowed.
+func checkLengths(codes []bitcode, allowIncomplete bool) error {
+	// Sum 2^-len over all codes, scaled by 2^maxCodeLen.
+	const one = 1 << maxCodeLen
+	var sum uint64
+	n := 0
+	for _, c := range codes {
+		if c.len != 0 {
+			sum += one >> c.len
+			n++
 		}
-		R := j - i
-		// Code C appears R times consecutively.
-		switch {
-		case L == 0:
-			rep(R, 7, 0)
+	}
+	switch {
+	case sum > one:
+		return errors.New("code lengths are oversubscribed")
+	case sum < one && !allowIncomplete && n > 0 && !(n == 1 && sum == one/2):
+		return errors.New("code lengths are incomplete")
+	}
+	return nil
+}
 
-		case L >= 1 && L <= 16:
-			rep(R, 2, byte((L-1)<<2|1))
+// MaxLen returns the length in bits of the longest code in c, including the escape code.
+// It is at most the MaxLen option used to construct c.
+// Since it depends only on the code lengths, it is the same for c and the
+// result of unmarshaling c.
+func (c *Code) MaxLen() int {
+	m := int(c.esc.len)
+	for _, bc := range c.codes {
+		m = max(m, int(bc.len))
+	}
+	return m
+}
 
-		case L >= 17 && L <= 20:
-			rep(R, 4, byte((L-17)<<2|0b11))
+// Escape returns the escape mode of c.
+func (c *Code) Escape() EscapeMode {
+	return c.escMode
+}
 
-		default:
-			panic(fmt.Sprintf("code out of range 0-20: %d", L))
+// Lengths returns the length in bits of the code for each symbol.
+// The value at index i is the length of the code for Symbol(i),
+// or 0 if the symbol has no code.
+// If c has an escape code, its length is the last element.
+// Passing the result to [NewCodeFromLengths] (or [NewCodeFromLengthsWithOptions],
+// for an incomplete code or one with an escape) reconstructs c.
+//
+// Lengths panics if c is a sparse Code with a symbol of 2^20 or more,
+// since the result would need an element for every smaller symbol.
+// Use [Code.SymbolLengths] for such Codes.
+func (c *Code) Lengths() []uint8 {
+	n := len(c.codes)
+	if len(c.syms) > 0 {
+		last := c.syms[len(c.syms)-1]
+		if last >= sparseThreshold {
+			panic(fmt.Sprintf("huffman: Lengths of sparse Code with symbol %d; use SymbolLengths", last))
 		}
-		i = j
+		n = int(last) + 1
 	}
-	return buf
-	// Encode the lengths of the bitcodes, in order.
-}
-
-// UnmarshalCode reconstructs a [Code] from the data, which must have been created with [Code.Marshal].
-func UnmarshalCode(data []byte) (*Code, error) {
-	if len(data) == 0 {
-		return nil, errors.New("huffman.UnmarshalCode: empty data")
+	lens := make([]uint8, n, n+1)
+	for i, bc := range c.codes {
+		lens[c.symbol(i)] = uint8(bc.len)
 	}
-	if data[0] != byte(0b11<<6|marshalVersion) {
-		return nil, errors.New("huffman.UnmarshalCode: bad magic/version")
+	if c.escMode != NoEscape {
+		lens = append(lens, uint8(c.esc.len))
 	}
-	var codes []bitcode
-	for _, b := range data[1:] {
-		var L, R byte
-		switch {
-		case b&1 == 0:
-			L = 0
-			R = b>>1 + 1
-		case b&3 == 1:
-			L = (b>>2)&15 + 1
-			R = b>>6 + 1
-		case b&3 == 3:
-			L = (b>>2)&3 + 17
-			R = b>>4 + 1
-		}
-		codes = slices.Grow(codes, int(R))
-		for range R {
-			codes = append(codes, bitcode{len: uint32(L)})
+	return lens
+}
+
+// SymbolLengths returns an iterator over the symbols that have codes in c,
+// in increasing order, and the lengths in bits of their codes.
+// Unlike [Code.Lengths], it works for any Code, and uses no memory
+// beyond c itself. It does not include the escape code.
+func (c *Code) SymbolLengths() iter.Seq2[Symbol, int] {
+	return func(yield func(Symbol, int) bool) {
+		for i, bc := range c.codes {
+			if bc.len > 0 && !yield(c.symbol(i), int(bc.len)) {
+				return
+			}
 		}
 	}
-	assignValues(codes)
-	return &Code{codes: codes}, nil
 }
 
 func assignValues(codes []bitcode) {
@@ -159,59 +320,260 @@ func assignValues(codes []bitcode) {
 	}
 	for i, c := range codes {
 		if c.len != 0 {
-			codes[i].val = uint32(reverseBits(uint16(nextVal[c.len]), byte(c.len)))
+			codes[i].val = reverseBits(nextVal[c.len], byte(c.len))
 			nextVal[c.len]++
 		}
 	}
 }
 
+// EncodedBits returns the number of bits needed to encode symbols with the
+// given frequencies, where frequencies[i] is the frequency of Symbol(i).
+// It does not include the trailing byte that [Encoder.Close] writes.
+// It returns an error if a frequency is negative, or if a symbol with a
+// nonzero frequency cannot be encoded.
+func (c *Code) EncodedBits(frequencies []int) (int, error) {
+	return c.encodedBits(denseFrequencies(frequencies))
+}
+
+func (c *Code) encodedBits(frequencies iter.Seq2[Symbol, int]) (int, error) {
+	total := 0
+	for s, f := range frequencies {
+		if f < 0 {
+			return 0, fmt.Errorf("huffman.Code.EncodedBits: negative frequency for symbol %d", s)
+		}
+		if f == 0 {
+			continue
+		}
+		n := int(c.code(s).len)
+		if n == 0 {
+			if c.escMode == NoEscape {
+				return 0, fmt.Errorf("huffman.Code.EncodedBits: no code for symbol %d", s)
+			}
+			n = c.escapedLen(s)
+		}
+		total += f * n
+	}
+	return total, nil
+}
+
+// denseFrequencies returns a sequence of the pairs (Symbol(i), frequencies[i]).
+func denseFrequencies(frequencies []int) iter.Seq2[Symbol, int] {
+	return func(yield func(Symbol, int) bool) {
+		for i, f := range frequencies {
+			if !yield(Symbol(i), f) {
+				return
+			}
+		}
+	}
+}
+
+// escapedLen returns the number of bits needed to write s
+// with the escape code.
+func (c *Code) escapedLen(s Symbol) int {
+	n := int(c.esc.len)
+	if c.escMode == EscapeGamma {
+		return n + gammaLen(s)
+	}
+	return n + 32
+}
+
 // TODO: is a code for (byte) faster?
 // TODO: just panic if out of range?
 func (c *Code) code(s Symbol) bitcode {
+	if c.syms != nil {
+		if i, ok := slices.BinarySearch(c.syms, s); ok {
+			return c.codes[i]
+		}
+		return bitcode{}
+	}
 	if s >= uint32(len(c.codes)) {
 		return bitcode{}
 	}
 	return c.codes[s]
 }
 
-// A SplitFunc splits bytes into symbols.
-// TODO: return (int, []Symbol), where the int is how many bytes consumed, so SplitFunc
-// doesn't have to consume all its input.
-type SplitFunc func([]byte) []Symbol
+// symbol returns the symbol whose code is c.codes[i].
+func (c *Code) symbol(i int) Symbol {
+	if c.syms != nil {
+		return c.syms[i]
+	}
+	return Symbol(i)
+}
+
+// A SplitFunc splits bytes into symbols, in the manner of [bufio.SplitFunc].
+// It is passed the bytes that have not yet been consumed, and returns
+// the number of bytes it consumed and the symbols for them.
+//
+// If atEOF is false, more data may follow, so the SplitFunc may leave bytes
+// at the end of data unconsumed, as when they are an incomplete token.
+// They will be passed again, followed by the next bytes written, on the next call.
+// A SplitFunc that returns an advance of zero is not called again until there is more data.
+// If atEOF is true, there is no more data, and the SplitFunc must consume all of it.
+//
+// If the SplitFunc returns an error, splitting stops and the error is returned
+// to the caller of the Write method or reported by Close.
+type SplitFunc func(data []byte, atEOF bool) (advance int, syms []Symbol, err error)
+
+// A splitter applies a SplitFunc to a sequence of writes.
+// It holds bytes that the SplitFunc did not consume until the next write.
+type splitter struct {
+	split SplitFunc
+	buf   []byte // bytes not yet consumed
+}
+
+// write calls the SplitFunc on the unconsumed bytes followed by data,
+// passing the resulting symbols to emit.
+// If atEOF is true, it is an error for any bytes to remain unconsumed.
+// If emit returns an error, write stops and returns it.
+func (sp *splitter) write(data []byte, atEOF bool, emit func([]Symbol) error) error {
+	in := data
+	if len(sp.buf) > 0 {
+		sp.buf = append(sp.buf, data...)
+		in = sp.buf
+	}
+	for len(in) > 0 {
+		advance, syms, err := sp.split(in, atEOF)
+		if err != nil {
+			sp.buf = sp.buf[:0]
+			return err
+		}
+		if advance < 0 || advance > len(in) {
+			sp.buf = sp.buf[:0]
+			return fmt.Errorf("huffman: SplitFunc returned advance %d for %d bytes", advance, len(in))
+		}
+		if err := emit(syms); err != nil {
+			sp.buf = sp.buf[:0]
+			return err
+		}
+		if advance == 0 {
+			break
+		}
+		in = in[advance:]
+	}
+	if atEOF && len(in) > 0 {
+		sp.buf = sp.buf[:0]
+		return fmt.Errorf("huffman: SplitFunc did not consume the final %d bytes", len(in))
+	}
+	// Keep the unconsumed bytes. They may already be at the end of sp.buf;
+	// append copies correctly even so.
+	sp.buf = append(sp.buf[:0], in...)
+	return nil
+}
+
+// A JoinFunc is the inverse of a [SplitFunc]: it converts symbols back to bytes.
+// It appends the bytes for syms to dst and returns the extended slice.
+// It returns an error if a symbol does not represent any bytes.
+type JoinFunc func(dst []byte, syms []Symbol) ([]byte, error)
+
+// A Tokenizer converts between bytes and symbols.
+// Its Split and Join functions should be inverses.
+// A Tokenizer's methods construct the values needed to encode and decode
+// with it. The zero Tokenizer treats each byte as a symbol.
+type Tokenizer struct {
+	Split SplitFunc
+	Join  JoinFunc
+}
+
+// NewCodeBuilder returns a [CodeBuilder] that uses t.Split.
+func (t Tokenizer) NewCodeBuilder() *CodeBuilder {
+	return NewCodeBuilder(t.Split)
+}
+
+// NewEncoder returns an [Encoder] for c that uses t.Split.
+func (t Tokenizer) NewEncoder(c *Code, w io.Writer) *Encoder {
+	return c.NewEncoder(w, t.Split)
+}
+
+// NewDecoder returns a [Decoder] for c whose [Decoder.DecodeTo] method uses t.Join.
+func (t Tokenizer) NewDecoder(c *Code) *Decoder {
+	d := c.NewDecoder()
+	d.join = t.Join
+	return d
+}
 
 // A CodeBuilder builds A [Code] from a sequence of bytes.
 // Call [NewCodeBuilder] to construct one, then write the bytes to it with [CodeBuilder.Write].
 // Call [CodeBuilder.Code] to retrieve the finished Code.
+//
+// A CodeBuilder counts symbols in a slice indexed by symbol until it sees
+// a symbol of 2^20 or more. It then switches to a map, and constructs
+// a sparse Code (see [NewSparseCode]).
 type CodeBuilder struct {
-	split SplitFunc
-	freqs []int
+	sp     *splitter // nil if there is no SplitFunc
+	freqs  []int
+	sparse map[Symbol]int // if non-nil, the frequencies; freqs is unused
 }
 
 // NewCodeBuilder constructs a [CodeBuilder].
 // If split is nil, each byte of the input is a separate symbol.
 // Otherwise, split is called to split the input bytes into symbols.
 func NewCodeBuilder(split SplitFunc) *CodeBuilder {
-	return &CodeBuilder{split: split}
+	cb := &CodeBuilder{}
+	if split != nil {
+		cb.sp = &splitter{split: split}
+	}
+	return cb
 }
 
 // Write adds the data to the sequence of symbols used to construct the [Code].
-// It always returns a nil error.
+// Bytes that the SplitFunc does not consume are held until the next call to Write,
+// or until [CodeBuilder.Code] is called.
+// Write returns a non-nil error only if the SplitFunc does.
 func (cb *CodeBuilder) Write(data []byte) (int, error) {
-	if cb.split != nil {
-		syms := cb.split(data)
-		for _, s := range syms {
-			cb.growFreqs(s)
-			cb.freqs[s]++
+	if cb.sp != nil {
+		if err := cb.sp.write(data, false, cb.addSymbols); err != nil {
+			return 0, err
 		}
 	} else {
 		for _, b := range data {
-			cb.growFreqs(uint32(b))
-			cb.freqs[b]++
+			cb.count(Symbol(b))
 		}
 	}
 	return len(data), nil
 }
 
+func (cb *CodeBuilder) addSymbols(syms []Symbol) error {
+	for _, s := range syms {
+		cb.count(s)
+	}
+	return nil
+}
+
+// count increments the frequency of s.
+func (cb *CodeBuilder) count(s Symbol) {
+	if cb.sparse == nil && s >= sparseThreshold {
+		cb.sparse = map[Symbol]int{}
+		for s, f := range cb.freqs {
+			if f > 0 {
+				cb.sparse[Symbol(s)] = f
+			}
+		}
+		cb.freqs = nil
+	}
+	if cb.sparse != nil {
+		cb.sparse[s]++
+		return
+	}
+	cb.growFreqs(s)
+	cb.freqs[s]++
+}
+
+// frequencies returns the frequencies of the symbols written to cb.
+func (cb *CodeBuilder) frequencies() iter.Seq2[Symbol, int] {
+	if cb.sparse != nil {
+		return maps.All(cb.sparse)
+	}
+	return denseFrequencies(cb.freqs)
+}
+
+// flush splits any bytes held by the SplitFunc.
+func (cb *CodeBuilder) flush() error {
+	if cb.sp == nil {
+		return nil
+	}
+	return cb.sp.write(nil, true, cb.addSymbols)
+}
+
 // growFreqs grows cb.freqs so that freqs[n] will not panic.
 func (cb *CodeBuilder) growFreqs(n uint32) {
 	ulen := uint32(len(cb.freqs))
@@ -225,134 +587,310 @@ func (cb *CodeBuilder) growFreqs(n uint32) {
 }
 
 // Code returns the constructed [Code].
+// It first passes any unconsumed bytes to the SplitFunc, with atEOF set to true.
 func (cb *CodeBuilder) Code() (*Code, error) {
-	return NewCode(cb.freqs)
+	return cb.CodeWithOptions(CodeOptions{})
+}
+
+// CodeWithOptions is like [CodeBuilder.Code], but takes options.
+func (cb *CodeBuilder) CodeWithOptions(opts CodeOptions) (*Code, error) {
+	if err := cb.flush(); err != nil {
+		return nil, err
+	}
+	if cb.sparse != nil {
+		return NewSparseCodeWithOptions(cb.sparse, opts)
+	}
+	return NewCodeWithOptions(cb.freqs, opts)
 }
 
 // An Encoder encodes symbols with a [Code] and writes them to an [io.Writer].
 // Create one with [NewEncoder], then add data with the Write, WriteBytes, WriteSymbol and WriteSymbols
 // methods. Finally, call Close to flush remaining data to the io.Writer.
+//
+// Errors are sticky: after a method returns an error, later calls do nothing
+// and return the same error, and Close returns it as well.
 type Encoder struct {
-	c     *Code
-	bw    *bitWriter
-	split SplitFunc
+	c   *Code
+	bw  *bitWriter
+	sp  *splitter // nil if there is no SplitFunc
+	n   int64     // number of symbols written
+	err error     // first error from the SplitFunc or from writing a symbol
+}
+
+// A SymbolError reports a symbol that an [Encoder] cannot encode,
+// because the symbol has no code and the [Code] has no escape code.
+type SymbolError struct {
+	Symbol Symbol
+	Pos    int64 // number of symbols written before Symbol
+}
+
+func (e *SymbolError) Error() string {
+	return fmt.Sprintf("huffman: no code for symbol %d at position %d", e.Symbol, e.Pos)
 }
 
 // NewEncoder constructs an [Encoder].
-// If split is nil, the [Code] must not have more than 256 symbols (one for each possible byte value).
+// If split is nil, each byte written with [Encoder.Write] is a symbol.
 func (c *Code) NewEncoder(w io.Writer, split SplitFunc) *Encoder {
-	if split == nil && len(c.codes) > 256 {
-		panic("no split func but more than 256 codes")
+	e := &Encoder{c: c, bw: newBitWriter(w)}
+	if split != nil {
+		e.sp = &splitter{split: split}
 	}
-	return &Encoder{c: c, bw: newBitWriter(w), split: split}
+	return e
 }
 
-// If there is no SplitFunc, it is an error if the Encoder's [Code] contains more than 256 symbols, or if any
-// of the byte values exceed the largest symbol, or if any of the byte values had
-// a zero frequency when the [Code] was constructed.
-// Always returns len(data), nil. Errors reported by [Encoder.Close].
+// Write encodes data. If there is a SplitFunc, it splits data into symbols;
+// otherwise each byte is a symbol.
+// Bytes that the SplitFunc does not consume are held until the next call to Write,
+// or until Close is called.
+// Write returns an error if the SplitFunc does, if a symbol cannot be encoded
+// (see [SymbolError]), or if writing to the underlying [io.Writer] fails.
 func (e *Encoder) Write(data []byte) (int, error) {
-	if e.split != nil {
-		e.WriteSymbols(e.split(data))
-	} else {
-		e.WriteBytes(data)
+	if e.sp == nil {
+		return writeBytes(data, e.bw, e.WriteSymbol)
 	}
-	return len(data), nil
+	return writeSplit(data, e.sp, &e.err, e.bw, e.WriteSymbols)
 }
 
 // WriteBytes writes the bytes to the encoder as separate symbols.
-// The encoder's split function must be nil, and every byte in the argument
-// must have a valid encoding.
-func (e *Encoder) WriteBytes(bs []byte) {
-	if e.split != nil {
-		panic("huffman.Encoder.WriteBytes called with no split function")
-	}
-	for _, b := range bs {
-		e.WriteSymbol(Symbol(b))
+// The encoder's split function must be nil.
+func (e *Encoder) WriteBytes(bs []byte) error {
+	if e.sp != nil {
+		return errors.New("huffman.Encoder.WriteBytes: encoder has a split function")
 	}
+	_, err := writeBytes(bs, e.bw, e.WriteSymbol)
+	return err
 }
 
 // WriteSymbol writes a symbol to the encoder.
-// It panics if there is no code for the given symbol.
-func (e *Encoder) WriteSymbol(s Symbol) {
+// If there is no code for the given symbol, it is written with
+// the Code's escape code. If there is no escape code, WriteSymbol
+// returns a [*SymbolError].
+func (e *Encoder) WriteSymbol(s Symbol) error {
+	if e.err != nil {
+		return e.err
+	}
 	// TODO: faster to have a specialized bits(byte)?
 	b := e.c.code(s)
 	if b.len == 0 {
-		panic(fmt.Sprintf("no code for symbol %d", s))
+		if e.c.escMode == NoEscape {
+			e.err = &SymbolError{Symbol: s, Pos: e.n}
+			return e.err
+		}
+		e.writeEscaped(s)
+	} else {
+		// TODO: benchmark if WriteBits takes a uint8, or bits.len is an int.
+		e.bw.writeBits(b.val, int(b.len))
 	}
-	// TODO: benchmark if WriteBits takes a uint8, or bits.len is an int.
-	e.bw.writeBits(b.val, int(b.len))
+	e.n++
+	return nil
 }
 
-// WriteSymbols calls [WriteSymbol] repeatedly.
-func (e *Encoder) WriteSymbols(syms []Symbol) {
-	for _, s := range syms {
-		e.WriteSymbol(s)
+// WriteSymbols calls [Encoder.WriteSymbol] for each symbol, stopping at the first error.
+func (e *Encoder) WriteSymbols(syms []Symbol) error {
+	return writeSymbols(syms, e.WriteSymbol)
+}
+
+// writeEscaped writes s with the escape code.
+func (e *Encoder) writeEscaped(s Symbol) {
+	e.bw.writeBits(e.c.esc.val, int(e.c.esc.len))
+	if e.c.escMode == EscapeGamma {
+		e.bw.writeGamma(s)
+	} else {
+		e.bw.writeBits(s, 32)
 	}
 }
 
-// Close writes remaining data to the encoder's writer.
+// Close passes any unconsumed bytes to the SplitFunc, with atEOF set to true,
+// and writes remaining data to the encoder's writer.
 func (e *Encoder) Close() error {
-	return e.bw.Close()
+	return closeEncoder(e.sp, &e.err, e.bw, e.WriteSymbols)
+}
+
+// The functions below implement the Write, WriteSymbols and Close methods
+// shared by the encoders in this package. Each encoder has a bitWriter bw,
+// a splitter sp that is nil if there is no SplitFunc, and a sticky error *errp.
+
+// writeBytes calls writeSymbol with each byte of bs as a symbol.
+func writeBytes(bs []byte, bw *bitWriter, writeSymbol func(Symbol) error) (int, error) {
+	for i, b := range bs {
+		if err := writeSymbol(Symbol(b)); err != nil {
+			return i, err
+		}
+	}
+	if err := bw.Err(); err != nil {
+		return 0, err
+	}
+	return len(bs), nil
+}
+
+// writeSplit passes data to sp, which calls writeSymbols with the symbols it splits off.
+func writeSplit(data []byte, sp *splitter, errp *error, bw *bitWriter, writeSymbols func([]Symbol) error) (int, error) {
+	if *errp == nil {
+		*errp = sp.write(data, false, writeSymbols)
+	}
+	if *errp != nil {
+		return 0, *errp
+	}
+	if err := bw.Err(); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// writeSymbols calls writeSymbol for each symbol, stopping at the first error.
+func writeSymbols(syms []Symbol, writeSymbol func(Symbol) error) error {
+	for _, s := range syms {
+		if err := writeSymbol(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeEncoder passes any unconsumed bytes to sp, if there is one, and closes bw.
+func closeEncoder(sp *splitter, errp *error, bw *bitWriter, writeSymbols func([]Symbol) error) error {
+	if sp != nil && *errp == nil {
+		*errp = sp.write(nil, true, writeSymbols)
+	}
+	if err := bw.Close(); *errp == nil {
+		*errp = err
+	}
+	return *errp
 }
 
 // A Decoder decodes data encoded by an Encoder.
+// Use [Decoder.Decode] to decode all the data at once, or call [Decoder.Reset]
+// and then [Decoder.ReadSymbol] to decode a symbol at a time.
 type Decoder struct {
 	table *table
+	br    *bitReader // set by Reset
+	join  JoinFunc   // used by DecodeTo
 }
 
+// NewDecoder returns a [Decoder] for c.
+// Decoders are cheap to create: all Decoders for c share its decoding table.
 func (c *Code) NewDecoder() *Decoder {
-	// TODO: build the table once, not once for each Decoder.
 	return &Decoder{
-		table: buildTable(c.codes),
+		table: c.decodingTable(0),
+	}
+}
+
+// DecoderOptions are options for constructing a [Decoder].
+type DecoderOptions struct {
+	// RootBits is the number of input bits that the Decoder looks up at once
+	// in its first table. Codes longer than RootBits need further lookups.
+	// A larger RootBits means fewer lookups, but a larger table, with 2^RootBits entries.
+	// It must be between 1 and 16. If zero, it is 8.
+	RootBits int
+}
+
+// NewDecoderWithOptions is like [Code.NewDecoder], but takes options.
+// All Decoders for c with the same RootBits share a decoding table.
+func (c *Code) NewDecoderWithOptions(opts DecoderOptions) (*Decoder, error) {
+	if opts.RootBits < 0 || opts.RootBits > maxRootBits {
+		return nil, fmt.Errorf("huffman.NewDecoder: RootBits %d out of range 1-%d", opts.RootBits, maxRootBits)
 	}
+	return &Decoder{table: c.decodingTable(opts.RootBits)}, nil
 }
 
-// A table maps bytes to actions.
-// The index byte might represent a complete 8-bit code, or one that is shorter or longer.
-// If 8 or shorter, action.len gives the length in bits, telling the Decoder how much of
-// the byte to consume from the input. A new byte is then constructed from the remainder of the
-// old byte with additional input bits, and then the table is indexed again.
+const (
+	defaultRootBits = 8  // width of a root table if DecoderOptions.RootBits is zero
+	maxRootBits     = 16 // maximum width of a root table
+	subTableBits    = 8  // width of other tables
+)
+
+// A table maps the next bits of input to actions.
+// Its index is the next t.bits bits, which might hold a complete code, or one that is shorter or longer.
+// If the code fits, action.len gives its length in bits, telling the Decoder how much of
+// the input to consume. The next index is then constructed from the remaining bits
+// with additional input bits, and the table is indexed again.
 //
-// If the code length exceeds 8 bits, the action points to another table, populated with values
+// If the code is longer than t.bits, the action points to another table, populated with values
 // using the code's remaining bits.
-type table [256]action
+type table struct {
+	bits    uint32   // the number of bits in an index
+	actions []action // 1<<bits actions
+}
+
+func newTable(bits uint32) *table {
+	return &table{bits: bits, actions: make([]action, 1<<bits)}
+}
 
 type action struct {
-	sym   Symbol // the symbol that this code represents
-	len   uint32 // the length of the code
-	table *table // if non-nil, then sym==0, len==8, and the code continues to the next table
+	sym   Symbol     // the symbol that this code represents
+	len   uint32     // the length of the code
+	table *table     // if non-nil, then sym==0, len is the width of this table, and the code continues to the next table
+	esc   EscapeMode // if not NoEscape, this is the escape code, and the symbol follows it
+}
+
+// A tableCache holds the decoding tables of a Code, built on first use.
+type tableCache struct {
+	tables [maxRootBits + 1]struct {
+		once  sync.Once
+		table *table
+	}
+}
+
+// decodingTable returns the decoding table for c whose root table has the given width,
+// building it the first time. If bits is zero, it uses defaultRootBits.
+func (c *Code) decodingTable(bits int) *table {
+	if bits == 0 {
+		bits = defaultRootBits
+	}
+	if c.tables == nil {
+		return c.buildTable(bits)
+	}
+	e := &c.tables.tables[bits]
+	e.once.Do(func() { e.table = c.buildTable(bits) })
+	return e.table
+}
+
+// buildTable builds a decoding table for c whose root table has the given width.
+func (c *Code) buildTable(bits int) *table {
+	return buildTable(bits, c.codes, c.syms, c.esc, c.escMode)
 }
 
-func buildTable(codes []bitcode) *table {
-	t := &table{}
-	for s, c := range codes {
+// buildTable builds a table of the given width for codes and the escape code esc, if mode is not NoEscape.
+// If syms is non-nil, codes[i] is the code for syms[i]; otherwise it is the code for Symbol(i).
+func buildTable(bits int, codes []bitcode, syms []Symbol, esc bitcode, mode EscapeMode) *table {
+	t := newTable(uint32(bits))
+	for i, c := range codes {
 		if c.len == 0 {
 			continue // symbol has no code (zero frequency)
 		}
-		t.add(c.val, c.len, Symbol(s))
+		s := Symbol(i)
+		if syms != nil {
+			s = syms[i]
+		}
+		t.add(c.val, c.len, action{sym: s})
+	}
+	if mode != NoEscape {
+		t.add(esc.val, esc.len, action{esc: mode})
 	}
 	return t
 }
 
-func (t *table) add(val, len uint32, sym Symbol) {
-	if len <= 8 {
+// add adds the code with value val and length len to t.
+// The code's action is a, with its len field set.
+func (t *table) add(val, len uint32, a action) {
+	if len <= t.bits {
 		// val occupies the low `len` bits. Fill all entries where those
-		// low bits match val and the upper (8-len) bits are anything.
-		for i := range 1 << (8 - len) {
+		// low bits match val and the upper (t.bits-len) bits are anything.
+		a.len = len
+		for i := range 1 << (t.bits - len) {
 			idx := (uint32(i) << len) | val
-			t[idx] = action{sym: sym, len: len}
+			t.actions[idx] = a
 		}
 	} else {
-		// Code is longer than 8 bits. The low 8 bits index this table;
+		// Code is longer than t.bits. The low t.bits bits index this table;
 		// the remaining bits index a sub-table.
-		idx := val & 0xFF
-		a := &t[idx]
-		if a.table == nil {
-			a.table = &table{}
-			a.len = 8
+		idx := val & (1<<t.bits - 1)
+		p := &t.actions[idx]
+		if p.table == nil {
+			p.table = newTable(subTableBits)
+			p.len = t.bits
 		}
-		a.table.add(val>>8, len-8, sym)
+		p.table.add(val>>t.bits, len-t.bits, a)
 	}
 }
 
@@ -360,53 +898,188 @@ func (t *table) add(val, len uint32, sym Symbol) {
 // The data must have been produced by an [Encoder]; the last byte is a trailer
 // indicating how many bits in the preceding byte are valid.
 func (d *Decoder) Decode(r io.Reader) ([]Symbol, error) {
-	br := newBitReader(r)
+	return decodeAll(d.Symbols(r))
+}
+
+// decodeAll collects the symbols of seq, stopping at the first error.
+func decodeAll(seq iter.Seq2[Symbol, error]) ([]Symbol, error) {
 	var syms []Symbol
-	for br.remaining != 0 {
-		// Peek at the next 8 bits (or fewer at the end).
-		b, err := br.peek()
-		if err == io.EOF {
-			return syms, nil
-		}
+	for s, err := range seq {
 		if err != nil {
 			return syms, err
 		}
-		a := d.table[b]
-		if a.len == 0 {
-			return syms, fmt.Errorf("huffman.Decode: invalid code at byte 0x%02x", b)
-		}
-		codeLen := int(a.len)
-		if a.table != nil {
-			// Code is longer than 8 bits. Consume the first 8 bits,
-			// then peek again and look up in the sub-table.
-			for n := 8; n > 0; {
-				chunk := min(n, 8)
-				if _, err := br.readBits(chunk); err != nil {
-					return syms, err
-				}
-				n -= chunk
-			}
-			b, err = br.peek()
-			if err != nil {
-				return syms, err
+		syms = append(syms, s)
+	}
+	return syms, nil
+}
+
+// Reset prepares d to decode the data in r with [Decoder.ReadSymbol].
+func (d *Decoder) Reset(r io.Reader) {
+	d.br = newBitReader(r)
+}
+
+// ReadSymbol decodes and returns the next symbol from the reader passed to [Decoder.Reset].
+// At the end of the data, it returns io.EOF.
+func (d *Decoder) ReadSymbol() (Symbol, error) {
+	if d.br == nil {
+		return 0, errors.New("huffman.Decoder.ReadSymbol: no reader; call Reset")
+	}
+	return decodeSymbol(d.br, d.table)
+}
+
+// Symbols returns an iterator over the symbols decoded from r.
+// If decoding fails, the iterator yields a final pair with the error.
+// Iteration resets d as if by [Decoder.Reset]; when it stops early,
+// d can continue decoding from r with [Decoder.ReadSymbol].
+func (d *Decoder) Symbols(r io.Reader) iter.Seq2[Symbol, error] {
+	return readSymbols(r, d.Reset, d.ReadSymbol)
+}
+
+// readSymbols returns an iterator that calls reset with r, then yields the results
+// of read until it returns io.EOF or another error.
+// It implements the Symbols method of a decoder.
+func readSymbols(r io.Reader, reset func(io.Reader), read func() (Symbol, error)) iter.Seq2[Symbol, error] {
+	return func(yield func(Symbol, error) bool) {
+		reset(r)
+		for {
+			s, err := read()
+			if err == io.EOF {
+				return
 			}
-			a = a.table[b]
-			if a.len == 0 {
-				return syms, fmt.Errorf("huffman.Decode: invalid code in sub-table at byte 0x%02x", b)
+			if !yield(s, err) || err != nil {
+				return
 			}
-			codeLen = int(a.len)
-		}
-		syms = append(syms, a.sym)
-		// Consume a.len bits.
-		n := codeLen
-		// Read those bits to advance the reader.
-		for n > 0 {
-			chunk := min(n, 8)
-			if _, err := br.readBits(chunk); err != nil {
-				return syms, err
+		}
+	}
+}
+
+// DecodeTo decodes the data in r and writes the corresponding bytes to w.
+// It returns the number of bytes written.
+// If d was created by [Tokenizer.NewDecoder], DecodeTo uses the Tokenizer's
+// JoinFunc to convert symbols to bytes. Otherwise, each symbol must be a byte.
+func (d *Decoder) DecodeTo(w io.Writer, r io.Reader) (int64, error) {
+	join := d.join
+	if join == nil {
+		join = joinBytes
+	}
+	var (
+		total int64
+		syms  = make([]Symbol, 0, 512)
+		buf   []byte
+	)
+	flush := func() error {
+		var err error
+		buf, err = join(buf[:0], syms)
+		if err != nil {
+			return err
+		}
+		syms = syms[:0]
+		n, err := w.Write(buf)
+		total += int64(n)
+		return err
+	}
+	for s, err := range d.Symbols(r) {
+		if err != nil {
+			return total, err
+		}
+		syms = append(syms, s)
+		if len(syms) == cap(syms) {
+			if err := flush(); err != nil {
+				return total, err
 			}
-			n -= chunk
 		}
 	}
-	return syms, nil
+	return total, flush()
+}
+
+// joinBytes is a [JoinFunc] for symbols that are bytes.
+func joinBytes(dst []byte, syms []Symbol) ([]byte, error) {
+	for _, s := range syms {
+		if s > 255 {
+			return dst, fmt.Errorf("huffman: decoded symbol %d is not a byte", s)
+		}
+		dst = append(dst, byte(s))
+	}
+	return dst, nil
+}
+
+// decodeSymbol reads the next symbol from br using t.
+// It returns io.EOF if there are no more symbols.
+func decodeSymbol(br *bitReader, t *table) (Symbol, error) {
+	// Peek at the next t.bits bits (or fewer at the end).
+	b, err := br.peekBits(int(t.bits))
+	if err != nil {
+		return 0, err
+	}
+	a := t.actions[b]
+	if a.len == 0 {
+		return 0, fmt.Errorf("huffman.Decode: invalid code at bits 0x%x", b)
+	}
+	for a.table != nil {
+		// Code is longer than t.bits. Consume the first t.bits bits,
+		// then peek again and look up in the sub-table.
+		// Long codes may have more than one sub-table.
+		if err := br.skipBits(int(a.len)); err != nil {
+			return 0, err
+		}
+		t = a.table
+		b, err = br.peekBits(int(t.bits))
+		if err != nil {
+			return 0, noEOF(err)
+		}
+		a = t.actions[b]
+		if a.len == 0 {
+			return 0, fmt.Errorf("huffman.Decode: invalid code in sub-table at bits 0x%x", b)
+		}
+	}
+	// Consume a.len bits.
+	if err := br.skipBits(int(a.len)); err != nil {
+		return 0, err
+	}
+	switch a.esc {
+	case EscapeFixed:
+		s, err := br.readBitsN(32)
+		return s, noEOF(err)
+	case EscapeGamma:
+		s, err := br.readGamma()
+		return s, noEOF(err)
+	}
+	return a.sym, nil
+}
+
+// NewReader returns an [io.Reader] that decodes the data in r,
+// which must have been encoded with c by an [Encoder] whose symbols are bytes.
+// Unlike [Decoder.Decode], the returned Reader decodes incrementally,
+// as the caller reads, so its memory use does not depend on the size of the data.
+// Its Read method returns an error if it decodes a symbol larger than 255.
+func (c *Code) NewReader(r io.Reader) io.Reader {
+	d := c.NewDecoder()
+	d.Reset(r)
+	return &codeReader{d: d}
+}
+
+type codeReader struct {
+	d   *Decoder
+	err error
+}
+
+func (r *codeReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && r.err == nil {
+		var s Symbol
+		s, r.err = r.d.ReadSymbol()
+		if r.err != nil {
+			break
+		}
+		if s > 255 {
+			r.err = fmt.Errorf("huffman: decoded symbol %d is not a byte", s)
+			break
+		}
+		p[n] = byte(s)
+		n++
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, r.err
 }
@@ -6,23 +6,33 @@ package huffman
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"runtime"
 	"slices"
+	"sync"
 	"testing"
+	"testing/iotest"
 )
 
 func TestEncoder(t *testing.T) {
 	// This is a simple test of an encoder. The Code is not a Huffman code.
 	// Every code is 8 bits to simplify comparisons.
-	c := &Code{
-		codes: []bitcode{
-			{0, 8},
-			{1, 8},
-			{2, 8},
-			{3, 8},
-		},
+	c, err := ParseCode(`
+		0 8 00000000
+		1 8 10000000
+		2 8 01000000
+		3 8 11000000
+	`)
+	if err != nil {
+		t.Fatal(err)
 	}
 
 	var buf bytes.Buffer
@@ -119,15 +129,17 @@ func TestCodeMarshal(t *testing.T) {
 			t.Errorf("%v:\ngot  %b\nwant %b", tc.lens, got, tc.want)
 		}
 
-		dec, err := UnmarshalCode(marsh)
+		// Most of these lengths are not valid codes, so UnmarshalCode would reject them.
+		// Check only the decoding of the lengths.
+		dec, err := unmarshalLengths(marsh[1:], maxSymbols)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if g, w := len(dec.codes), len(c.codes); g != w {
+		if g, w := len(dec), len(c.codes); g != w {
 			t.Fatalf("#%d: decoded %d codes, wanted %d", tci, g, w)
 		}
 		for i := range len(c.codes) {
-			if g, w := c.codes[i].len, dec.codes[i].len; g != w {
+			if g, w := c.codes[i].len, dec[i].len; g != w {
 				t.Errorf("#%d: %3d: %d != %d", tci, i, g, w)
 			}
 		}
@@ -233,6 +245,269 @@ func testRoundTrip(t *testing.T, input []byte, split SplitFunc) {
 	}
 }
 
+func TestCodeReader(t *testing.T) {
+	input, err := os.ReadFile(filepath.Join("testdata", "pride-and-prejudice.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := NewCodeBuilder(nil)
+	cb.Write(input)
+	code, err := cb.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc := code.NewEncoder(&buf, nil)
+	enc.Write(input)
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	if err := iotest.TestReader(code.NewReader(bytes.NewReader(encoded)), input); err != nil {
+		t.Error(err)
+	}
+	// Read the encoded data a byte at a time.
+	got, err := io.ReadAll(code.NewReader(iotest.OneByteReader(bytes.NewReader(encoded))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, input) {
+		t.Errorf("OneByteReader: got %d bytes, want %d", len(got), len(input))
+	}
+	// Truncated data.
+	if _, err := io.ReadAll(code.NewReader(bytes.NewReader(encoded[:len(encoded)/2]))); err == nil {
+		t.Error("truncated: got nil, want error")
+	}
+
+	// A symbol that isn't a byte.
+	code, err = NewCode([]int{300: 1, 301: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	enc = code.NewEncoder(&buf, func([]byte, bool) (int, []Symbol, error) { return 0, nil, nil }) // dummy split
+	enc.WriteSymbols([]Symbol{300, 301})
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(code.NewReader(&buf)); err == nil {
+		t.Error("non-byte symbol: got nil, want error")
+	}
+}
+
+func TestDecodersShareTable(t *testing.T) {
+	input, err := os.ReadFile(filepath.Join("testdata", "pride-and-prejudice.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := NewCodeBuilder(nil)
+	cb.Write(input)
+	code, err := cb.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc := code.NewEncoder(&buf, nil)
+	enc.Write(input)
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	// Decoders created concurrently share one table.
+	const n = 8
+	var wg sync.WaitGroup
+	tables := make([]*table, n)
+	for i := range n {
+		wg.Go(func() {
+			d := code.NewDecoder()
+			tables[i] = d.table
+			var out bytes.Buffer
+			if _, err := d.DecodeTo(&out, bytes.NewReader(encoded)); err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(out.Bytes(), input) {
+				t.Error("decoded data differs")
+			}
+		})
+	}
+	wg.Wait()
+	for i := 1; i < n; i++ {
+		if tables[i] != tables[0] {
+			t.Fatalf("decoder %d has a different table", i)
+		}
+	}
+
+	// A Code that was not made by a constructor still works.
+	var c Code
+	c.codes = code.codes
+	got, err := io.ReadAll(c.NewReader(bytes.NewReader(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, input) {
+		t.Error("zero Code: decoded data differs")
+	}
+}
+
+func TestDecoderReadSymbol(t *testing.T) {
+	freqs := []int{5, 9, 12, 13, 16, 45}
+	code, err := NewCode(freqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbols := []Symbol{0, 1, 2, 3, 4, 5, 5, 5, 4, 3, 2, 1, 0}
+	var buf bytes.Buffer
+	enc := code.NewEncoder(&buf, nil)
+	enc.WriteSymbols(symbols)
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	dec := code.NewDecoder()
+	if _, err := dec.ReadSymbol(); err == nil {
+		t.Error("ReadSymbol before Reset: got nil, want error")
+	}
+
+	dec.Reset(bytes.NewReader(encoded))
+	var got []Symbol
+	for {
+		s, err := dec.ReadSymbol()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, s)
+	}
+	if !slices.Equal(got, symbols) {
+		t.Errorf("ReadSymbol: got %v, want %v", got, symbols)
+	}
+
+	// Stop iterating early, then continue with ReadSymbol.
+	got = nil
+	for s, err := range dec.Symbols(bytes.NewReader(encoded)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, s)
+		if len(got) == 5 {
+			break
+		}
+	}
+	if !slices.Equal(got, symbols[:5]) {
+		t.Errorf("Symbols: got %v, want %v", got, symbols[:5])
+	}
+	s, err := dec.ReadSymbol()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != symbols[5] {
+		t.Errorf("ReadSymbol after Symbols: got %d, want %d", s, symbols[5])
+	}
+
+	// Errors are yielded.
+	var gotErr error
+	for _, err := range dec.Symbols(bytes.NewReader(encoded[:2])) {
+		gotErr = err
+	}
+	if gotErr == nil {
+		t.Error("Symbols on truncated data: got nil, want error")
+	}
+}
+
+func TestTokenizer(t *testing.T) {
+	input, err := os.ReadFile(filepath.Join("testdata", "pride-and-prejudice.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("bytes", func(t *testing.T) {
+		testTokenizerRoundTrip(t, input, Tokenizer{})
+	})
+	// Each pair of bytes is a symbol.
+	pairs := Tokenizer{
+		Split: func(data []byte, atEOF bool) (int, []Symbol, error) {
+			if atEOF && len(data)%2 != 0 {
+				return 0, nil, errors.New("odd number of bytes")
+			}
+			var syms []Symbol
+			i := 0
+			for ; i+1 < len(data); i += 2 {
+				syms = append(syms, Symbol(data[i])<<8|Symbol(data[i+1]))
+			}
+			return i, syms, nil
+		},
+		Join: func(dst []byte, syms []Symbol) ([]byte, error) {
+			for _, s := range syms {
+				dst = append(dst, byte(s>>8), byte(s))
+			}
+			return dst, nil
+		},
+	}
+	t.Run("pairs", func(t *testing.T) {
+		testTokenizerRoundTrip(t, input[:len(input)&^1], pairs)
+	})
+	t.Run("odd", func(t *testing.T) {
+		cb := pairs.NewCodeBuilder()
+		cb.Write([]byte("abc"))
+		if _, err := cb.Code(); err == nil {
+			t.Error("CodeBuilder.Code: got nil, want error")
+		}
+		code, err := NewCode([]int{'a'<<8 | 'b': 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc := pairs.NewEncoder(code, io.Discard)
+		enc.Write([]byte("abc"))
+		if err := enc.Close(); err == nil {
+			t.Error("Encoder.Close: got nil, want error")
+		}
+	})
+}
+
+// testTokenizerRoundTrip builds a Code for input with tok, encodes input,
+// and checks that decoding it produces input.
+func testTokenizerRoundTrip(t *testing.T, input []byte, tok Tokenizer) {
+	t.Helper()
+	// Write in small pieces, so tokens span calls to Write.
+	const chunk = 7
+	cb := tok.NewCodeBuilder()
+	for in := input; len(in) > 0; in = in[min(chunk, len(in)):] {
+		if _, err := cb.Write(in[:min(chunk, len(in))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	code, err := cb.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc := tok.NewEncoder(code, &buf)
+	for in := input; len(in) > 0; in = in[min(chunk, len(in)):] {
+		enc.Write(in[:min(chunk, len(in))])
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	n, err := tok.NewDecoder(code).DecodeTo(&out, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(out.Len()) {
+		t.Errorf("DecodeTo returned %d, wrote %d bytes", n, out.Len())
+	}
+	if got := out.Bytes(); !bytes.Equal(got, input) {
+		max := min(len(input), len(got), 100)
+		t.Errorf("round trip failed: got %d bytes, want %d bytes\n  got[:100]  %q\n  want[:100] %q",
+			len(got), len(input), got[:max], input[:max])
+	}
+}
+
 func TestMarshalUnmarshalRoundTrip(t *testing.T) {
 	// Test that Marshal -> UnmarshalCode produces a Code that
 	// encodes and decodes identically to the original.
@@ -410,7 +685,7 @@ func TestRoundTripLargeAlphabet(t *testing.T) {
 
 	// Encode using WriteSymbols (required for >256-symbol alphabets without a SplitFunc).
 	var buf bytes.Buffer
-	enc := code.NewEncoder(&buf, func(b []byte) []Symbol { return nil }) // dummy split
+	enc := code.NewEncoder(&buf, func([]byte, bool) (int, []Symbol, error) { return 0, nil, nil }) // dummy split
 	enc.WriteSymbols(symbols)
 	if err := enc.Close(); err != nil {
 		t.Fatal(err)
@@ -438,6 +713,370 @@ func TestRoundTripLargeAlphabet(t *testing.T) {
 	}
 }
 
+func TestRoundTripHugeAlphabet(t *testing.T) {
+	// An alphabet of 500,000 symbols, most of which do not occur.
+	// Many of the symbols that do occur are larger than 2^16.
+	const numSymbols = 500_000
+	freqs := make([]int, numSymbols)
+	var symbols []Symbol
+	for i := 0; i < numSymbols; i += 97 {
+		freqs[i] = i%7 + 1
+		symbols = append(symbols, Symbol(i))
+	}
+	freqs[numSymbols-1] = 3
+	symbols = append(symbols, numSymbols-1)
+	code, err := NewCode(freqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range freqs {
+		if (f == 0) != (code.codes[i].len == 0) {
+			t.Fatalf("symbol %d: frequency %d, code length %d", i, f, code.codes[i].len)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := code.NewEncoder(&buf, func([]byte, bool) (int, []Symbol, error) { return 0, nil, nil }) // dummy split
+	enc.WriteSymbols(symbols)
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := code.NewDecoder().Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, symbols) {
+		t.Fatalf("round trip failed: got %d symbols, want %d", len(got), len(symbols))
+	}
+}
+
+func TestNewCodeTooManySymbols(t *testing.T) {
+	freqs := slices.Repeat([]int{1}, 1<<maxCodeLen+1)
+	if _, err := NewCode(freqs); err == nil {
+		t.Error("got nil, want error")
+	}
+}
+
+func TestLengthLimitedCodes(t *testing.T) {
+	// Fibonacci frequencies produce the deepest possible Huffman trees.
+	fib := []int{1, 1}
+	for len(fib) < 40 {
+		fib = append(fib, fib[len(fib)-1]+fib[len(fib)-2])
+	}
+	const seed = 5
+	r := rand.New(rand.NewPCG(seed, seed))
+	random := make([]int, 3000)
+	for i := range random {
+		random[i] = r.IntN(1<<(i%25)) + 1
+	}
+	for _, freqs := range [][]int{fib, random, {1, 2, 3}, {100, 1, 1, 1, 1}} {
+		for maxBits := 1; maxBits <= maxCodeLen; maxBits++ {
+			if len(freqs) > 1<<maxBits {
+				continue
+			}
+			h := newHuffmanEncoder(len(freqs))
+			h.generate(freqs, int32(maxBits))
+			kraft := 0.0
+			for i, c := range h.codes {
+				if c.len == 0 || int(c.len) > maxBits {
+					t.Fatalf("n=%d, maxBits=%d: code %d has length %d", len(freqs), maxBits, i, c.len)
+				}
+				kraft += math.Ldexp(1, -int(c.len))
+			}
+			if kraft != 1 {
+				t.Errorf("n=%d, maxBits=%d: Kraft sum is %g, want 1", len(freqs), maxBits, kraft)
+			}
+			if got, want := h.bitLength(freqs), packageMergeCost(freqs, maxBits); got != want {
+				t.Errorf("n=%d, maxBits=%d: cost %d, want %d", len(freqs), maxBits, got, want)
+			}
+		}
+	}
+}
+
+func TestLongCodes(t *testing.T) {
+	// Fibonacci frequencies force codes up to maxCodeLen bits.
+	freqs := []int{1, 1}
+	for len(freqs) < 30 {
+		freqs = append(freqs, freqs[len(freqs)-1]+freqs[len(freqs)-2])
+	}
+	code, err := NewCode(freqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := code.codes[0].len; got != maxCodeLen {
+		t.Fatalf("longest code has length %d, want %d", got, maxCodeLen)
+	}
+	// Values assigned from the lengths must match the ones from code construction.
+	dec, err := UnmarshalCode(code.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dec.codes, code.codes) {
+		t.Errorf("unmarshaled codes differ:\ngot  %v\nwant %v", dec.codes, code.codes)
+	}
+	var symbols []Symbol
+	for i := range freqs {
+		symbols = append(symbols, Symbol(i), 0)
+	}
+	var buf bytes.Buffer
+	enc := code.NewEncoder(&buf, nil)
+	enc.WriteSymbols(symbols)
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := dec.NewDecoder().Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, symbols) {
+		t.Errorf("got %v, want %v", got, symbols)
+	}
+}
+
+func TestDecoderRootBits(t *testing.T) {
+	pride, err := os.ReadFile(filepath.Join("testdata", "pride-and-prejudice.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := NewCodeBuilder(nil)
+	cb.Write(pride)
+	prideCode, err := cb.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prideSyms []Symbol
+	for _, b := range pride {
+		prideSyms = append(prideSyms, Symbol(b))
+	}
+
+	// Fibonacci frequencies force codes up to maxCodeLen bits.
+	freqs := []int{1, 1}
+	for len(freqs) < 30 {
+		freqs = append(freqs, freqs[len(freqs)-1]+freqs[len(freqs)-2])
+	}
+	longCode, err := NewCodeWithOptions(freqs, CodeOptions{Escape: EscapeGamma})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var longSyms []Symbol
+	for i := range freqs {
+		longSyms = append(longSyms, Symbol(i), 29, 100)
+	}
+
+	oneCode, err := NewCode([]int{'x': 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		code *Code
+		syms []Symbol
+	}{
+		{"pride", prideCode, prideSyms},
+		{"long", longCode, longSyms},
+		{"one", oneCode, []Symbol{'x', 'x', 'x'}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := tc.code.NewEncoder(&buf, nil)
+			if err := enc.WriteSymbols(tc.syms); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			for bits := range maxRootBits + 1 {
+				d, err := tc.code.NewDecoderWithOptions(DecoderOptions{RootBits: bits})
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := d.Decode(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatalf("RootBits %d: %v", bits, err)
+				}
+				if !slices.Equal(got, tc.syms) {
+					t.Errorf("RootBits %d: decoded symbols differ", bits)
+				}
+				d2, _ := tc.code.NewDecoderWithOptions(DecoderOptions{RootBits: bits})
+				if d2.table != d.table {
+					t.Errorf("RootBits %d: table not shared", bits)
+				}
+			}
+			if tc.code.NewDecoder().table != tc.code.decodingTable(defaultRootBits) {
+				t.Error("NewDecoder does not use the default RootBits")
+			}
+		})
+	}
+
+	for _, bits := range []int{-1, maxRootBits + 1} {
+		if _, err := prideCode.NewDecoderWithOptions(DecoderOptions{RootBits: bits}); err == nil {
+			t.Errorf("RootBits %d: got nil, want error", bits)
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	pride, err := os.ReadFile(filepath.Join("testdata", "pride-and-prejudice.txt"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	// Use about 1 MiB of input, so that the time to build the decoding
+	// tables does not dominate.
+	const size = 1 << 20
+	text := bytes.Repeat(pride, size/len(pride)+1)[:size]
+	// A Zipf distribution gives many long codes, which need sub-table lookups
+	// when the root table is narrow.
+	r := rand.New(rand.NewPCG(1, 2))
+	z := rand.NewZipf(r, 1.1, 1, 255)
+	zipf := make([]byte, size)
+	for i := range zipf {
+		zipf[i] = byte(z.Uint64())
+	}
+
+	for _, in := range []struct {
+		name  string
+		input []byte
+	}{
+		{"text", text},
+		{"zipf", zipf},
+	} {
+		cb := NewCodeBuilder(nil)
+		cb.Write(in.input)
+		code, err := cb.Code()
+		if err != nil {
+			b.Fatal(err)
+		}
+		var buf bytes.Buffer
+		enc := code.NewEncoder(&buf, nil)
+		enc.Write(in.input)
+		if err := enc.Close(); err != nil {
+			b.Fatal(err)
+		}
+		encoded := buf.Bytes()
+
+		for _, bits := range []int{8, 9, 10, 11, 12} {
+			b.Run(fmt.Sprintf("%s/root=%d", in.name, bits), func(b *testing.B) {
+				d, err := code.NewDecoderWithOptions(DecoderOptions{RootBits: bits})
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.SetBytes(int64(len(in.input)))
+				var out bytes.Buffer
+				for b.Loop() {
+					out.Reset()
+					if _, err := d.DecodeTo(&out, bytes.NewReader(encoded)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCodeOptions(t *testing.T) {
+	input, err := os.ReadFile(filepath.Join("testdata", "pride-and-prejudice.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := NewCodeBuilder(nil)
+	cb.Write(input)
+	for _, maxLen := range []int{7, 9, 11} {
+		code, err := cb.CodeWithOptions(CodeOptions{MaxLen: maxLen})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := code.MaxLen(); got > maxLen {
+			t.Errorf("MaxLen() = %d, want at most %d", got, maxLen)
+		}
+		for i, c := range code.codes {
+			if int(c.len) > maxLen {
+				t.Fatalf("MaxLen %d: symbol %d has length %d", maxLen, i, c.len)
+			}
+		}
+		var buf bytes.Buffer
+		enc := code.NewEncoder(&buf, nil)
+		enc.Write(input)
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		syms, err := code.NewDecoder().Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(syms) != len(input) {
+			t.Fatalf("MaxLen %d: decoded %d symbols, want %d", maxLen, len(syms), len(input))
+		}
+
+		dec, err := UnmarshalCode(code.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := dec.MaxLen(), code.MaxLen(); got != want {
+			t.Errorf("unmarshaled MaxLen() = %d, want %d", got, want)
+		}
+	}
+
+	code, err := NewCode([]int{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := code.MaxLen(), 2; got != want {
+		t.Errorf("MaxLen() = %d, want %d", got, want)
+	}
+	dec, err := UnmarshalCode(code.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dec.MaxLen(), 2; got != want {
+		t.Errorf("unmarshaled MaxLen() = %d, want %d", got, want)
+	}
+
+	for _, tc := range []struct {
+		freqs  []int
+		maxLen int
+	}{
+		{[]int{1, 2, 3}, 1},
+		{slices.Repeat([]int{1}, 257), 8},
+		{[]int{1}, -1},
+		{[]int{1}, maxCodeLen + 1},
+	} {
+		if _, err := NewCodeWithOptions(tc.freqs, CodeOptions{MaxLen: tc.maxLen}); err == nil {
+			t.Errorf("%d frequencies, MaxLen %d: got nil, want error", len(tc.freqs), tc.maxLen)
+		}
+	}
+}
+
+// packageMergeCost returns the total number of bits needed to encode the
+// frequencies with an optimal code whose lengths are at most maxBits.
+// It uses the straightforward package-merge algorithm.
+func packageMergeCost(freqs []int, maxBits int) int {
+	leaves := slices.Clone(freqs)
+	slices.Sort(leaves)
+	n := len(leaves)
+	if n <= 2 {
+		total := 0
+		for _, f := range leaves {
+			total += f
+		}
+		return total
+	}
+	list := leaves
+	for range maxBits - 1 {
+		var pkgs []int
+		for i := 0; i+1 < len(list); i += 2 {
+			pkgs = append(pkgs, list[i]+list[i+1])
+		}
+		list = append(slices.Clone(leaves), pkgs...)
+		slices.Sort(list)
+	}
+	total := 0
+	for _, w := range list[:2*n-2] {
+		total += w
+	}
+	return total
+}
+
 func FuzzRoundTrip(f *testing.F) {
 	// Seed corpus.
 	f.Add([]byte("a man a plan a canal panama"))
@@ -486,6 +1125,199 @@ func FuzzRoundTrip(f *testing.F) {
 	})
 }
 
+func TestUnmarshalCodeErrors(t *testing.T) {
+	v1 := append([]byte{0b11000001}, appendLengthsV1(nil, []bitcode{{len: 1}, {len: 1}})...)
+	v1Truncated := slices.Clone(v1[:len(v1)-2])
+	v1Extra := append(slices.Clone(v1[:len(v1)-1]), 0xff, 8)
+	v1Manual := func(n int, clLens map[int]uint32, syms ...uint32) []byte {
+		var buf bytes.Buffer
+		bw := newBitWriter(&buf)
+		for i := range rleSymbols {
+			bw.writeBits(clLens[i], 3)
+		}
+		for _, s := range syms {
+			bw.writeBits(s, 1)
+		}
+		bw.Close()
+		return append(binary.AppendUvarint([]byte{0b11000001}, uint64(n)), buf.Bytes()...)
+	}
+	v1RepeatFirst := v1Manual(3, map[int]uint32{rleRepeat: 1}, 0, 0, 0)
+	// Symbol 1 has code 0 and rleZeros has code 1; the extra bits (zero) are read one at a time.
+	v1Overrun := v1Manual(4, map[int]uint32{1: 1, rleZeros: 1}, 0, 0, 1, 0, 0, 0)
+	v1Huge := v1HugeCount(1 << 20)
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", []byte{0b01000000}},
+		{"bad version", []byte{0b11000011}},
+		// Three codes of length 1.
+		{"oversubscribed", []byte{0b11000000, 0b10_0000_01}},
+		// Four codes of length 1, preceded by zeros.
+		{"oversubscribed after zeros", []byte{0b11000000, 0b1000, 0b11_0000_01}},
+		{"gamma without escape", []byte{0b11010000, 0b00_0000_01}},
+		{"no escape length", []byte{0b11100000}},
+		{"zero escape length", []byte{0b11100000, 0b00_0000_01, 0b0}},
+		{"v1 no count", []byte{0b11000001}},
+		{"v1 no lengths", []byte{0b11000001, 2}},
+		{"v1 truncated", v1Truncated},
+		{"v1 extra data", v1Extra},
+		// The code-length code has a single symbol, 21 (repeat), of length 1.
+		{"v1 repeat first", v1RepeatFirst},
+		// Two codes of length 1 followed by a run of 3 zeros (symbol 22) that overruns the count.
+		{"v1 overrun", v1Overrun},
+		// A count far larger than the few runs of zeros that follow.
+		{"v1 count exceeds data", v1Huge},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := UnmarshalCode(tc.data); err == nil {
+				t.Error("got nil, want error")
+			} else {
+				t.Log(err)
+			}
+		})
+	}
+
+	// Incomplete codes are allowed.
+	if _, err := UnmarshalCode([]byte{0b11000000, 0b00_0000_01, 0b00_0001_01}); err != nil {
+		t.Errorf("incomplete code: %v", err)
+	}
+
+	// A huge count must be rejected before memory is allocated for it,
+	// even when MaxSymbols allows it.
+	data := v1HugeCount(1 << 32)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := UnmarshalCodeWithOptions(data, UnmarshalOptions{MaxSymbols: 1 << 32}); err == nil {
+		t.Error("huge count: got nil, want error")
+	}
+	runtime.ReadMemStats(&after)
+	if got := after.TotalAlloc - before.TotalAlloc; got > 1<<20 {
+		t.Errorf("huge count: allocated %d bytes", got)
+	}
+}
+
+// v1HugeCount returns a marshaled version 1 Code that claims n lengths
+// but holds only a few maximal runs of zeros, each taking 17 bits.
+func v1HugeCount(n uint64) []byte {
+	var buf bytes.Buffer
+	bw := newBitWriter(&buf)
+	for i := range rleSymbols {
+		if i == 1 || i == rleHugeZeros {
+			bw.writeBits(1, 3) // symbol 1 has code 0, rleHugeZeros has code 1
+		} else {
+			bw.writeBits(0, 3)
+		}
+	}
+	for range 4 {
+		bw.writeBits(1, 1)
+		bw.writeBits(0xffff, 16)
+	}
+	bw.Close()
+	return append(binary.AppendUvarint([]byte{0b11000001}, n), buf.Bytes()...)
+}
+
+func TestUnmarshalCodeMaxSymbols(t *testing.T) {
+	// Each 0xfe byte is a run of 128 zero lengths, so this is just over 2^20 lengths.
+	huge := append([]byte{0b11000000}, bytes.Repeat([]byte{0xfe}, 1<<20/128)...)
+	huge = append(huge, 0b00_0000_01)
+	if _, err := UnmarshalCode(huge); err == nil {
+		t.Error("over default limit: got nil, want error")
+	}
+	if _, err := UnmarshalCodeWithOptions(huge, UnmarshalOptions{MaxSymbols: 1<<20 + 1}); err != nil {
+		t.Errorf("under MaxSymbols: %v", err)
+	}
+
+	freqs := []int{1, 2, 3, 4}
+	for _, tc := range []struct {
+		name string
+		code func() (*Code, error)
+	}{
+		{"dense", func() (*Code, error) { return NewCode(freqs) }},
+		{"dense_escape", func() (*Code, error) { return NewCodeWithOptions(freqs, CodeOptions{Escape: EscapeFixed}) }},
+		{"sparse", func() (*Code, error) { return NewSparseCode(map[Symbol]int{0: 1, 10: 2, 1 << 30: 3, 1 << 31: 4}) }},
+		{"v1", func() (*Code, error) {
+			freqs := make([]int, 5000)
+			for i := range freqs {
+				freqs[i] = 1 + i%37
+			}
+			return NewCode(freqs)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			code, err := tc.code()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := code.Marshal()
+			n := len(code.codes)
+			if _, err := UnmarshalCodeWithOptions(data, UnmarshalOptions{MaxSymbols: n}); err != nil {
+				t.Errorf("MaxSymbols %d: %v", n, err)
+			}
+			if _, err := UnmarshalCodeWithOptions(data, UnmarshalOptions{MaxSymbols: n - 1}); err == nil {
+				t.Errorf("MaxSymbols %d: got nil, want error", n-1)
+			}
+		})
+	}
+
+	if _, err := UnmarshalCodeWithOptions([]byte{0b11000000}, UnmarshalOptions{MaxSymbols: -1}); err == nil {
+		t.Error("negative MaxSymbols: got nil, want error")
+	}
+}
+
+func FuzzUnmarshalCode(f *testing.F) {
+	f.Add([]byte{0b11000000})
+	f.Add([]byte{0b11000000, 0b00_0000_01, 0b01_0001_01, 0b10_0100_01})
+	f.Add([]byte{0b11000000, 0b0001_11_11, 0b0000_00_11})
+	f.Add([]byte{0b11000000, 127 << 1, 0b01_0000_01})
+	f.Add([]byte{0b11000000, 0b10_0000_01})
+	f.Add(append([]byte{0b11000001}, appendLengthsV1(nil, []bitcode{{len: 1}, {len: 2}, {}, {}, {}, {len: 2}})...))
+	f.Add([]byte{0b11001000, 3, 0, 9, 0xe8, 0x07, 0b00_0000_01, 0b01_0001_01})
+	f.Add(append([]byte{0b11100001}, appendLengthsV1(nil, []bitcode{{len: 2}, {len: 2}, {len: 2}, {len: 3}, {len: 3}})...))
+	f.Add(v1HugeCount(1 << 20))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		code, err := UnmarshalCode(data)
+		if err != nil {
+			return
+		}
+		// Re-marshaling must produce an equivalent Code.
+		code2, err := UnmarshalCode(code.Marshal())
+		if err != nil {
+			t.Fatalf("unmarshaling re-marshaled code: %v", err)
+		}
+		if !slices.Equal(code.codes, code2.codes) || !slices.Equal(code.syms, code2.syms) {
+			t.Fatal("re-marshaled code differs")
+		}
+
+		// Every coded symbol must round-trip through the encoder and decoder.
+		var symbols []Symbol
+		for i, c := range code.codes {
+			if c.len != 0 {
+				symbols = append(symbols, code.symbol(i))
+			}
+			if len(symbols) >= 1000 {
+				break
+			}
+		}
+		var buf bytes.Buffer
+		enc := code.NewEncoder(&buf, func([]byte, bool) (int, []Symbol, error) { return 0, nil, nil }) // dummy split
+		enc.WriteSymbols(symbols)
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := code.NewDecoder().Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, symbols) {
+			t.Fatalf("got %v, want %v", got, symbols)
+		}
+	})
+}
+
 func TestAssignValues(t *testing.T) {
 	// Example from RFC 1951, section 3.2.2.
 	codes := []bitcode{{0, 2}, {0, 1}, {0, 3}, {0, 3}}
@@ -495,3 +1327,219 @@ func TestAssignValues(t *testing.T) {
 		t.Errorf("got %v, want %v", codes, want)
 	}
 }
+
+func TestNewCodeFromLengths(t *testing.T) {
+	// Example from RFC 1951, section 3.2.2.
+	code, err := NewCodeFromLengths([]uint8{2, 1, 3, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bitcode{{1, 2}, {0, 1}, {3, 3}, {7, 3}}
+	if !slices.Equal(code.codes, want) {
+		t.Errorf("got %v, want %v", code.codes, want)
+	}
+
+	// Lengths round-trip through a Code built from frequencies.
+	input, err := os.ReadFile(filepath.Join("testdata", "pride-and-prejudice.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := NewCodeBuilder(nil)
+	cb.Write(input)
+	orig, err := cb.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err = NewCodeFromLengths(orig.Lengths())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(code.codes, orig.codes) {
+		t.Error("code from lengths differs from original")
+	}
+
+	for _, tc := range []struct {
+		lens []uint8
+		opts CodeOptions
+		ok   bool
+	}{
+		{nil, CodeOptions{}, true},
+		{[]uint8{0, 0}, CodeOptions{}, true},
+		{[]uint8{0, 1, 0}, CodeOptions{}, true},  // single code
+		{[]uint8{1, 1, 1}, CodeOptions{}, false}, // oversubscribed
+		{[]uint8{1, 1, 1}, CodeOptions{AllowIncomplete: true}, false},
+		{[]uint8{1, 2}, CodeOptions{}, false}, // incomplete
+		{[]uint8{1, 2}, CodeOptions{AllowIncomplete: true}, true},
+		{[]uint8{2}, CodeOptions{}, false}, // single code, but not length 1
+		{[]uint8{1, 2, 3, 3}, CodeOptions{MaxLen: 2}, false},
+		{[]uint8{21}, CodeOptions{AllowIncomplete: true}, false},
+	} {
+		_, err := NewCodeFromLengthsWithOptions(tc.lens, tc.opts)
+		if got := err == nil; got != tc.ok {
+			t.Errorf("%v, %+v: got error %v, want ok=%t", tc.lens, tc.opts, err, tc.ok)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	freqs := make([]int, 256)
+	for _, b := range []byte("the quick brown fox") {
+		freqs[b]++
+	}
+	input := []Symbol{'t', 'h', 'e', 0, 'x', 'Z', 255, 256, 65535, 1 << 20, 'q', math.MaxUint32}
+	for _, mode := range []EscapeMode{EscapeFixed, EscapeGamma} {
+		t.Run(mode.String(), func(t *testing.T) {
+			code, err := NewCodeWithOptions(freqs, CodeOptions{Escape: mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code.Escape() != mode {
+				t.Errorf("Escape() = %s, want %s", code.Escape(), mode)
+			}
+			var buf bytes.Buffer
+			enc := code.NewEncoder(&buf, nil)
+			enc.WriteSymbols(input)
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			// Check EncodedBits against the actual size, ignoring the trailer.
+			// The last symbol is too large for a frequency slice.
+			symFreqs := make([]int, 1<<20+1)
+			for _, s := range input[:len(input)-1] {
+				symFreqs[s]++
+			}
+			bits, err := code.EncodedBits(symFreqs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bits += code.escapedLen(math.MaxUint32)
+			if got, want := buf.Len()-1, (bits+7)/8; got != want {
+				t.Errorf("encoded %d bytes, want %d", got, want)
+			}
+
+			// The escape code survives marshaling and Lengths.
+			code2, err := UnmarshalCode(code.Marshal())
+			if err != nil {
+				t.Fatal(err)
+			}
+			code3, err := NewCodeFromLengthsWithOptions(code.Lengths(), CodeOptions{Escape: mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range []*Code{code, code2, code3} {
+				if c.esc != code.esc || c.escMode != mode || !slices.Equal(c.codes, code.codes) {
+					t.Fatalf("got escape %v %s, want %v %s", c.esc, c.escMode, code.esc, mode)
+				}
+				got, err := c.NewDecoder().Decode(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(got, input) {
+					t.Errorf("got %v, want %v", got, input)
+				}
+			}
+
+			// A truncated escaped symbol is an error.
+			var buf2 bytes.Buffer
+			bw := newBitWriter(&buf2)
+			bw.writeBits(code.esc.val, int(code.esc.len))
+			bw.writeBits(0, 3)
+			bw.Close()
+			if _, err := code.NewDecoder().Decode(&buf2); err != io.ErrUnexpectedEOF {
+				t.Errorf("truncated: got %v, want %v", err, io.ErrUnexpectedEOF)
+			}
+		})
+	}
+}
+
+func TestEscapeOnly(t *testing.T) {
+	// A Code with no symbols but an escape can encode anything.
+	code, err := NewCodeWithOptions(nil, CodeOptions{Escape: EscapeGamma})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := code.Lengths(); !slices.Equal(got, []uint8{1}) {
+		t.Errorf("Lengths() = %v, want [1]", got)
+	}
+	var buf bytes.Buffer
+	enc := code.NewEncoder(&buf, nil)
+	enc.WriteBytes([]byte("abc"))
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := code.NewDecoder().Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Symbol{'a', 'b', 'c'}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := NewCodeFromLengthsWithOptions([]uint8{1, 0}, CodeOptions{Escape: EscapeFixed}); err == nil {
+		t.Error("zero escape length: got nil, want error")
+	}
+}
+
+func TestEncoderErrors(t *testing.T) {
+	code, err := NewCode([]int{'a': 1, 'b': 2, 300: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A symbol with no code.
+	enc := code.NewEncoder(io.Discard, nil)
+	n, err := enc.Write([]byte("abba!b"))
+	var serr *SymbolError
+	if !errors.As(err, &serr) {
+		t.Fatalf("got %v, want SymbolError", err)
+	}
+	if want := (SymbolError{Symbol: '!', Pos: 4}); *serr != want {
+		t.Errorf("got %+v, want %+v", *serr, want)
+	}
+	if n != 4 {
+		t.Errorf("Write returned %d, want 4", n)
+	}
+	// The error is sticky.
+	if err := enc.WriteSymbol('a'); err != serr {
+		t.Errorf("WriteSymbol after error: got %v, want %v", err, serr)
+	}
+	if err := enc.Close(); err != serr {
+		t.Errorf("Close: got %v, want %v", err, serr)
+	}
+
+	// Symbols beyond 255 with no SplitFunc.
+	enc = code.NewEncoder(io.Discard, nil)
+	if err := enc.WriteSymbols([]Symbol{'a', 300, 'b'}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteSymbols([]Symbol{'a', 301}); !errors.As(err, &serr) || serr.Pos != 4 {
+		t.Errorf("got %v, want SymbolError at position 4", err)
+	}
+
+	// WriteBytes with a SplitFunc.
+	enc = code.NewEncoder(io.Discard, Runes(RejectInvalid).Split)
+	if err := enc.WriteBytes([]byte("a")); err == nil {
+		t.Error("WriteBytes with SplitFunc: got nil, want error")
+	}
+	// A SymbolError from a split symbol.
+	if _, err := enc.Write([]byte("ab€")); !errors.As(err, &serr) || serr.Symbol != '€' {
+		t.Errorf("got %v, want SymbolError for €", err)
+	}
+
+	// A write error.
+	werr := errors.New("write failed")
+	enc = code.NewEncoder(errWriter{werr}, nil)
+	for range 10 {
+		enc.Write([]byte("abab"))
+	}
+	if _, err := enc.Write([]byte("a")); err != werr {
+		t.Errorf("Write: got %v, want %v", err, werr)
+	}
+	if err := enc.Close(); err != werr {
+		t.Errorf("Close: got %v, want %v", err, werr)
+	}
+}
+
+type errWriter struct{ err error }
+
+func (w errWriter) Write([]byte) (int, error) { return 0, w.err }
@@ -0,0 +1,507 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Versions of the marshaled form of a Code.
+const (
+	marshalVersion0 = 0 // byte-oriented run-length encoding of the lengths
+	marshalVersion1 = 1 // Huffman-coded run-length encoding of the lengths, as in RFC 1951
+)
+
+// Bits of the first byte of a marshaled Code.
+const (
+	marshalMagic       = 0b11 << 6 // the top two bits are always 1
+	marshalEscape      = 1 << 5    // the Code has an escape code
+	marshalEscapeGamma = 1 << 4    // the escape mode is EscapeGamma, not EscapeFixed
+	marshalSparse      = 1 << 3    // the Code is sparse
+	marshalVersionMask = 0b111
+)
+
+// Marshal compactly represents the Code as a sequence of bytes.
+func (c *Code) Marshal() []byte {
+	return c.appendMarshal(nil)
+}
+
+// appendMarshal appends the result of [Code.Marshal] to buf.
+func (c *Code) appendMarshal(buf []byte) []byte {
+	// Encode the lengths of the bitcodes, in order.
+	// First byte: version number in the low three bits, with the top two bits 1's as a tiny magic header.
+	// Bit 5 is set if there is an escape code, and bit 4 if its mode is EscapeGamma.
+	// Bit 3 is set if the Code is sparse; then the symbols follow, before the lengths.
+	// If there is an escape code, its length follows the others.
+	// The lengths are in whichever version's format is shorter.
+	header := byte(marshalMagic)
+	codes := c.codes
+	switch c.escMode {
+	case EscapeFixed:
+		header |= marshalEscape
+	case EscapeGamma:
+		header |= marshalEscape | marshalEscapeGamma
+	}
+	if c.escMode != NoEscape {
+		codes = append(slices.Clip(codes), c.esc)
+	}
+	lens := appendLengthsV0(nil, codes)
+	header |= marshalVersion0
+	if v1 := appendLengthsV1(nil, codes); len(v1) < len(lens) {
+		lens = v1
+		header = header&^marshalVersionMask | marshalVersion1
+	}
+	if c.syms != nil {
+		buf = append(buf, header|marshalSparse)
+		buf = appendSymbols(buf, c.syms)
+	} else {
+		buf = append(buf, header)
+	}
+	return append(buf, lens...)
+}
+
+// appendSymbols appends the sorted symbols of a sparse Code to buf:
+// their number, then the first symbol, then the gap before each of
+// the others, less one, all as uvarints.
+func appendSymbols(buf []byte, syms []Symbol) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(syms)))
+	prev := int64(-1)
+	for _, s := range syms {
+		buf = binary.AppendUvarint(buf, uint64(int64(s)-prev-1))
+		prev = int64(s)
+	}
+	return buf
+}
+
+// unmarshalSymbols decodes symbols written by appendSymbols.
+// It returns the symbols and the rest of data.
+// It returns an error if there are more than limit symbols.
+func unmarshalSymbols(data []byte, limit uint64) ([]Symbol, []byte, error) {
+	n, k := binary.Uvarint(data)
+	if k <= 0 {
+		return nil, nil, errors.New("huffman.UnmarshalCode: bad number of symbols")
+	}
+	data = data[k:]
+	if n > limit {
+		return nil, nil, fmt.Errorf("huffman.UnmarshalCode: %d symbols is more than %d", n, limit)
+	}
+	// Each symbol takes at least one byte.
+	if n > uint64(len(data)) {
+		return nil, nil, errors.New("huffman.UnmarshalCode: too many symbols")
+	}
+	syms := make([]Symbol, 0, n)
+	next := uint64(0) // the smallest possible value of the next symbol
+	for range n {
+		gap, k := binary.Uvarint(data)
+		if k <= 0 {
+			return nil, nil, fmt.Errorf("huffman.UnmarshalCode: bad gap after %d symbols", len(syms))
+		}
+		data = data[k:]
+		s := next + gap
+		if s < next || s >= maxSymbols {
+			return nil, nil, fmt.Errorf("huffman.UnmarshalCode: symbol after %d symbols is out of range", len(syms))
+		}
+		syms = append(syms, Symbol(s))
+		next = s + 1
+	}
+	return syms, data, nil
+}
+
+// appendLengthsV0 appends the lengths of codes to buf in the version 0 format.
+func appendLengthsV0(buf []byte, codes []bitcode) []byte {
+	// This format is simple and byte-oriented.
+	// There are three forms of byte:
+	//   RRRRRRR0:  length 0, with 7 bits of repeat (1-128)
+	//   RRLLLL01:  lengths 1-16, with 2 bits of repeat (1-4)
+	//   RRRRLL11:  lengths 17-20, with 4 bits of repeat (1-16)
+	rep := func(R, len int, bottom byte) {
+		shift := 8 - len
+		max := 1 << len
+		for R >= max {
+			buf = append(buf, byte((max-1)<<shift)|bottom)
+			R -= max
+		}
+		if R > 0 {
+			buf = append(buf, byte((R-1)<<shift)|bottom)
+		}
+	}
+
+	i := 0
+	for i < len(codes) {
+		L := codes[i].len
+		var j int
+		for j = i + 1; j < len(codes) && codes[j].len == L; j++ {
+		}
+		R := j - i
+		// Code C appears R times consecutively.
+		switch {
+		case L == 0:
+			rep(R, 7, 0)
+
+		case L >= 1 && L <= 16:
+			rep(R, 2, byte((L-1)<<2|1))
+
+		case L >= 17 && L <= 20:
+			rep(R, 4, byte((L-17)<<2|0b11))
+
+		default:
+			panic(fmt.Sprintf("code out of range 0-20: %d", L))
+		}
+		i = j
+	}
+	return buf
+}
+
+// The version 1 format is like the one in RFC 1951, section 3.2.7.
+// The lengths are run-length encoded with an alphabet of rleSymbols symbols,
+// and those symbols are encoded with a Huffman code, the code-length code,
+// whose lengths are at most rleMaxLen.
+//
+// The format is the number of lengths as a uvarint, followed by a bit stream
+// as written by a bitWriter, including its trailer byte. The bit stream holds
+// the lengths of the code-length code, 3 bits each, then the encoded symbols.
+// Symbols 0 through maxCodeLen are literal lengths. The others are
+// followed by extra bits giving a repeat count.
+const (
+	rleRepeat    = maxCodeLen + 1 + iota // repeat the previous length 3-6 times; 2 extra bits
+	rleZeros                             // 3-10 zero lengths; 3 extra bits
+	rleLongZeros                         // 11-138 zero lengths; 7 extra bits
+	rleHugeZeros                         // 139-65674 zero lengths; 16 extra bits
+	rleSymbols
+)
+
+const rleMaxLen = 7
+
+// rleExtra describes the repeat count of a run-length symbol: it is base plus
+// the value of the next nbits bits.
+var rleExtra = [rleSymbols]struct{ base, nbits int }{
+	rleRepeat:    {3, 2},
+	rleZeros:     {3, 3},
+	rleLongZeros: {11, 7},
+	rleHugeZeros: {139, 16},
+}
+
+// appendLengthsV1 appends the lengths of codes to buf in the version 1 format.
+func appendLengthsV1(buf []byte, codes []bitcode) []byte {
+	type token struct {
+		sym   int
+		extra uint32
+	}
+	var toks []token
+	// emit adds the largest run of the given symbol that fits in n, and returns its length.
+	emit := func(sym, n int) int {
+		e := rleExtra[sym]
+		r := min(n, e.base+1<<e.nbits-1)
+		toks = append(toks, token{sym, uint32(r - e.base)})
+		return r
+	}
+	for i := 0; i < len(codes); {
+		L := codes[i].len
+		j := i + 1
+		for j < len(codes) && codes[j].len == L {
+			j++
+		}
+		n := j - i
+		if L == 0 {
+			for n >= rleExtra[rleZeros].base {
+				switch {
+				case n >= rleExtra[rleHugeZeros].base:
+					n -= emit(rleHugeZeros, n)
+				case n >= rleExtra[rleLongZeros].base:
+					n -= emit(rleLongZeros, n)
+				default:
+					n -= emit(rleZeros, n)
+				}
+			}
+		} else {
+			toks = append(toks, token{int(L), 0})
+			n--
+			for n >= rleExtra[rleRepeat].base {
+				n -= emit(rleRepeat, n)
+			}
+		}
+		for range n {
+			toks = append(toks, token{int(L), 0})
+		}
+		i = j
+	}
+
+	freqs := make([]int, rleSymbols)
+	for _, t := range toks {
+		freqs[t.sym]++
+	}
+	cl, err := NewCodeWithOptions(freqs, CodeOptions{MaxLen: rleMaxLen})
+	if err != nil {
+		panic(err) // rleSymbols codes always fit in rleMaxLen bits
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(codes)))
+	var b bytes.Buffer
+	bw := newBitWriter(&b)
+	for _, c := range cl.codes {
+		bw.writeBits(c.len, 3)
+	}
+	for _, t := range toks {
+		c := cl.codes[t.sym]
+		bw.writeBits(c.val, int(c.len))
+		if n := rleExtra[t.sym].nbits; n > 0 {
+			bw.writeBits(t.extra, n)
+		}
+	}
+	bw.Close() // writes to a bytes.Buffer do not fail
+	return append(buf, b.Bytes()...)
+}
+
+// UnmarshalCode reconstructs a [Code] from the data, which must have been created with [Code.Marshal].
+// It returns an error if the data is malformed, or if it does not describe a prefix code.
+// The resulting Code may be incomplete, if the original one was.
+// To protect against hostile data, it rejects Codes with more than 2^20 symbols;
+// use [UnmarshalCodeWithOptions] to change the limit.
+func UnmarshalCode(data []byte) (*Code, error) {
+	return UnmarshalCodeWithOptions(data, UnmarshalOptions{})
+}
+
+// UnmarshalOptions are options for [UnmarshalCodeWithOptions].
+type UnmarshalOptions struct {
+	// MaxSymbols is the maximum size of the Code's alphabet: the number of code lengths
+	// for a dense Code, or the number of symbols with codes for a sparse one,
+	// not counting the escape code. Data describing a larger Code is rejected before
+	// memory for it is allocated.
+	// It must be between 0 and 2^32. If zero, 2^20 is used.
+	MaxSymbols int
+}
+
+// defaultMaxUnmarshalSymbols is the default for [UnmarshalOptions.MaxSymbols].
+// A [CodeBuilder] constructs a sparse Code for larger symbols.
+const defaultMaxUnmarshalSymbols = sparseThreshold
+
+// UnmarshalCodeWithOptions is like [UnmarshalCode], but takes options.
+func UnmarshalCodeWithOptions(data []byte, opts UnmarshalOptions) (*Code, error) {
+	limit := uint64(opts.MaxSymbols)
+	if opts.MaxSymbols < 0 || limit > maxSymbols {
+		return nil, fmt.Errorf("huffman.UnmarshalCode: MaxSymbols %d out of range 0-2^32", opts.MaxSymbols)
+	}
+	if limit == 0 {
+		limit = defaultMaxUnmarshalSymbols
+	}
+	if len(data) == 0 {
+		return nil, errors.New("huffman.UnmarshalCode: empty data")
+	}
+	if data[0]&marshalMagic != marshalMagic {
+		return nil, fmt.Errorf("huffman.UnmarshalCode: bad magic number in first byte 0x%02x", data[0])
+	}
+	v := data[0] & marshalVersionMask
+	if v != marshalVersion0 && v != marshalVersion1 {
+		return nil, fmt.Errorf("huffman.UnmarshalCode: unsupported version %d", v)
+	}
+	mode := NoEscape
+	switch {
+	case data[0]&marshalEscape == 0:
+		if data[0]&marshalEscapeGamma != 0 {
+			return nil, fmt.Errorf("huffman.UnmarshalCode: bad flags in first byte 0x%02x", data[0])
+		}
+	case data[0]&marshalEscapeGamma != 0:
+		mode = EscapeGamma
+	default:
+		mode = EscapeFixed
+	}
+	rest := data[1:]
+	var syms []Symbol
+	if data[0]&marshalSparse != 0 {
+		var err error
+		syms, rest, err = unmarshalSymbols(rest, limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if mode != NoEscape {
+		limit++ // for the escape code's length
+	}
+	var codes []bitcode
+	var err error
+	if v == marshalVersion0 {
+		codes, err = unmarshalLengths(rest, limit)
+	} else {
+		codes, err = unmarshalLengthsV1(rest, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if mode != NoEscape && (len(codes) == 0 || codes[len(codes)-1].len == 0) {
+		return nil, errors.New("huffman.UnmarshalCode: missing escape code length")
+	}
+	if syms != nil {
+		want := len(syms)
+		if mode != NoEscape {
+			want++
+		}
+		if len(codes) != want {
+			return nil, fmt.Errorf("huffman.UnmarshalCode: %d lengths for %d symbols", len(codes), len(syms))
+		}
+		for i, s := range syms {
+			if codes[i].len == 0 {
+				return nil, fmt.Errorf("huffman.UnmarshalCode: symbol %d of sparse code has no length", s)
+			}
+		}
+	}
+	// Codes built from lengths may be incomplete, so allow that here.
+	if err := checkLengths(codes, true); err != nil {
+		return nil, fmt.Errorf("huffman.UnmarshalCode: %w", err)
+	}
+	assignValues(codes)
+	c := &Code{codes: codes, syms: syms, tables: &tableCache{}}
+	c.splitEscape(mode)
+	return c, nil
+}
+
+// unmarshalLengths decodes code lengths in the version 0 format.
+// It returns an error if there are more than limit lengths.
+func unmarshalLengths(data []byte, limit uint64) ([]bitcode, error) {
+	var codes []bitcode
+	for i, b := range data {
+		var L, R byte
+		switch {
+		case b&1 == 0:
+			L = 0
+			R = b>>1 + 1
+		case b&3 == 1:
+			L = (b>>2)&15 + 1
+			R = b>>6 + 1
+		case b&3 == 3:
+			L = (b>>2)&3 + 17
+			R = b>>4 + 1
+		}
+		if uint64(len(codes))+uint64(R) > limit {
+			return nil, fmt.Errorf("huffman.UnmarshalCode: byte %d: more than %d lengths", i+1, limit)
+		}
+		codes = slices.Grow(codes, int(R))
+		for range R {
+			codes = append(codes, bitcode{len: uint32(L)})
+		}
+	}
+	return codes, nil
+}
+
+// unmarshalLengthsV1 decodes code lengths in the version 1 format.
+// It returns an error if there are more than limit lengths.
+func unmarshalLengthsV1(data []byte, limit uint64) ([]bitcode, error) {
+	n, k := binary.Uvarint(data)
+	if k <= 0 {
+		return nil, errors.New("huffman.UnmarshalCode: bad number of lengths")
+	}
+	if n > limit {
+		return nil, fmt.Errorf("huffman.UnmarshalCode: %d lengths is more than %d", n, limit)
+	}
+	// The densest encoding is a run of rleHugeZeros, each with a one-bit code.
+	// Reject a count that the rest of the data could not hold, before allocating.
+	hz := rleExtra[rleHugeZeros]
+	if maxLens := (uint64(len(data)-k)*8/uint64(1+hz.nbits) + 1) * uint64(hz.base+1<<hz.nbits-1); n > maxLens {
+		return nil, fmt.Errorf("huffman.UnmarshalCode: %d lengths cannot fit in %d bytes", n, len(data)-k)
+	}
+	br := newBitReader(bytes.NewReader(data[k:]))
+	clLens := make([]uint8, rleSymbols)
+	for i := range clLens {
+		l, err := br.readBits(3)
+		if err != nil {
+			return nil, fmt.Errorf("huffman.UnmarshalCode: reading code-length code: %w", noEOF(err))
+		}
+		clLens[i] = l
+	}
+	cl, err := NewCodeFromLengthsWithOptions(clLens, CodeOptions{MaxLen: rleMaxLen, AllowIncomplete: true})
+	if err != nil {
+		return nil, fmt.Errorf("huffman.UnmarshalCode: bad code-length code: %w", err)
+	}
+	t := cl.buildTable(rleMaxLen)
+	var codes []bitcode
+	for uint64(len(codes)) < n {
+		s, err := decodeSymbol(br, t)
+		if err != nil {
+			return nil, fmt.Errorf("huffman.UnmarshalCode: length %d: %w", len(codes), noEOF(err))
+		}
+		if s <= maxCodeLen {
+			codes = append(codes, bitcode{len: s})
+			continue
+		}
+		e := rleExtra[s]
+		extra, err := br.readBitsN(e.nbits)
+		if err != nil {
+			return nil, fmt.Errorf("huffman.UnmarshalCode: length %d: %w", len(codes), noEOF(err))
+		}
+		r := e.base + int(extra)
+		if uint64(len(codes)+r) > n {
+			return nil, fmt.Errorf("huffman.UnmarshalCode: length %d: repeat count %d overruns %d lengths", len(codes), r, n)
+		}
+		var L uint32
+		if s == rleRepeat {
+			if len(codes) == 0 {
+				return nil, errors.New("huffman.UnmarshalCode: repeat with no previous length")
+			}
+			L = codes[len(codes)-1].len
+		}
+		for range r {
+			codes = append(codes, bitcode{len: L})
+		}
+	}
+	if _, err := br.peek(); err != io.EOF {
+		if err == nil {
+			err = errors.New("extra data")
+		}
+		return nil, fmt.Errorf("huffman.UnmarshalCode: after lengths: %w", err)
+	}
+	return codes, nil
+}
+
+// MarshalBinary implements [encoding.BinaryMarshaler].
+// It returns the result of [Code.Marshal].
+func (c *Code) MarshalBinary() ([]byte, error) {
+	return c.Marshal(), nil
+}
+
+// AppendBinary implements [encoding.BinaryAppender].
+// It appends the result of [Code.Marshal] to b.
+func (c *Code) AppendBinary(b []byte) ([]byte, error) {
+	return c.appendMarshal(b), nil
+}
+
+// UnmarshalBinary implements [encoding.BinaryUnmarshaler].
+// It sets c to the result of [UnmarshalCode].
+// It must not be called while c is in use by other goroutines, or by an [Encoder] or [Decoder].
+func (c *Code) UnmarshalBinary(data []byte) error {
+	c2, err := UnmarshalCode(data)
+	if err != nil {
+		return err
+	}
+	*c = *c2
+	return nil
+}
+
+// MarshalText implements [encoding.TextMarshaler].
+// It returns the result of [Code.Marshal] in standard base64 encoding.
+// To see the codes themselves, use [Code.String].
+func (c *Code) MarshalText() ([]byte, error) {
+	return c.AppendText(nil)
+}
+
+// AppendText implements [encoding.TextAppender].
+// It appends the result of [Code.MarshalText] to b.
+func (c *Code) AppendText(b []byte) ([]byte, error) {
+	return base64.StdEncoding.AppendEncode(b, c.Marshal()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+// It decodes text produced by [Code.MarshalText].
+func (c *Code) UnmarshalText(text []byte) error {
+	data, err := base64.StdEncoding.AppendDecode(nil, text)
+	if err != nil {
+		return fmt.Errorf("huffman.Code.UnmarshalText: %w", err)
+	}
+	return c.UnmarshalBinary(data)
+}
@@ -0,0 +1,176 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*Code)(nil)
+	_ encoding.BinaryAppender    = (*Code)(nil)
+	_ encoding.BinaryUnmarshaler = (*Code)(nil)
+	_ encoding.TextMarshaler     = (*Code)(nil)
+	_ encoding.TextAppender      = (*Code)(nil)
+	_ encoding.TextUnmarshaler   = (*Code)(nil)
+)
+
+func TestCodeEncodingInterfaces(t *testing.T) {
+	code, err := NewCodeWithOptions([]int{1, 2, 0, 4, 8, 0, 0, 16}, CodeOptions{Escape: EscapeFixed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(t *testing.T, got *Code) {
+		t.Helper()
+		if !slices.Equal(got.codes, code.codes) || got.esc != code.esc || got.escMode != code.escMode {
+			t.Errorf("got\n%v\nwant\n%v", got, code)
+		}
+	}
+
+	t.Run("binary", func(t *testing.T) {
+		data, err := code.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, code.Marshal()) {
+			t.Error("MarshalBinary differs from Marshal")
+		}
+		prefix := []byte("prefix")
+		data2, err := code.AppendBinary(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data2, append(prefix, data...)) {
+			t.Error("AppendBinary differs from MarshalBinary")
+		}
+		var got Code
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		check(t, &got)
+		if err := got.UnmarshalBinary([]byte{0}); err == nil {
+			t.Error("bad data: got nil, want error")
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		text, err := code.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		text2, err := code.AppendText([]byte("x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(text2[1:], text) {
+			t.Error("AppendText differs from MarshalText")
+		}
+		var got Code
+		if err := got.UnmarshalText(text); err != nil {
+			t.Fatal(err)
+		}
+		check(t, &got)
+		if err := got.UnmarshalText([]byte("!!")); err == nil {
+			t.Error("bad base64: got nil, want error")
+		}
+	})
+
+	type S struct {
+		Name string
+		Code *Code
+	}
+
+	t.Run("json", func(t *testing.T) {
+		data, err := json.Marshal(S{"c", code})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%s", data)
+		var got S
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		check(t, got.Code)
+	})
+
+	t.Run("gob", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(S{"c", code}); err != nil {
+			t.Fatal(err)
+		}
+		var got S
+		if err := gob.NewDecoder(&buf).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		check(t, got.Code)
+	})
+}
+
+func TestMarshalLengthsV1(t *testing.T) {
+	for _, lens := range [][]int{
+		nil,
+		{0},
+		{1, 1},
+		{3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 2, 0, 0, 0, 1},
+		append(append([]int{20, 20}, slices.Repeat([]int{0}, 11)...), 19, 1),
+		append(slices.Repeat([]int{0}, 138), slices.Repeat([]int{7}, 7)...),
+		append(slices.Repeat([]int{0}, 139), 2),
+		slices.Repeat([]int{0}, 100_000),
+	} {
+		var codes []bitcode
+		for _, l := range lens {
+			codes = append(codes, bitcode{len: uint32(l)})
+		}
+		data := appendLengthsV1(nil, codes)
+		got, err := unmarshalLengthsV1(data, maxSymbols)
+		if err != nil {
+			t.Fatalf("%v: %v", lens, err)
+		}
+		if !slices.Equal(got, codes) {
+			t.Errorf("%v: got %v", lens, got)
+		}
+	}
+}
+
+func TestMarshalChoosesVersion(t *testing.T) {
+	// A code for many symbols with varied lengths is smaller in version 1.
+	freqs := make([]int, 50_000)
+	for i := range freqs {
+		if i%3 != 0 {
+			freqs[i] = 1 + i%97
+		}
+	}
+	code, err := NewCodeWithOptions(freqs, CodeOptions{Escape: EscapeGamma})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := code.Marshal()
+	if v := data[0] & marshalVersionMask; v != marshalVersion1 {
+		t.Errorf("got version %d, want %d", v, marshalVersion1)
+	}
+	v0 := appendLengthsV0(nil, append(slices.Clip(code.codes), code.esc))
+	t.Logf("version 0: %d bytes; version 1: %d bytes", len(v0)+1, len(data))
+	got, err := UnmarshalCode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.codes, code.codes) || got.esc != code.esc || got.escMode != code.escMode {
+		t.Error("round trip failed")
+	}
+
+	// A small code is smaller in version 0.
+	code, err = NewCode([]int{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := code.Marshal()[0] & marshalVersionMask; v != marshalVersion0 {
+		t.Errorf("small code: got version %d, want %d", v, marshalVersion0)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"errors"
+	"io"
+)
+
+// SemiAdaptiveOptions are options for a [SemiAdaptiveEncoder] and [SemiAdaptiveDecoder].
+// The encoder and decoder must use the same options.
+type SemiAdaptiveOptions struct {
+	// Interval is the number of symbols between rebuilds of the Code.
+	// If zero, 4096 is used.
+	Interval int
+
+	// MaxLen is the maximum length of a rebuilt code, as in [CodeOptions].
+	MaxLen int
+}
+
+const defaultSemiAdaptiveInterval = 4096
+
+// A rebuilder counts symbols and periodically rebuilds a Code from the counts.
+type rebuilder struct {
+	initial *Code
+	opts    SemiAdaptiveOptions
+	cb      CodeBuilder // counts
+	code    *Code       // the current Code
+	n       int         // symbols counted since the last rebuild
+}
+
+func newRebuilder(c *Code, opts SemiAdaptiveOptions) *rebuilder {
+	if c == nil {
+		lens := make([]uint8, 256)
+		for i := range lens {
+			lens[i] = 8
+		}
+		var err error
+		c, err = NewCodeFromLengths(lens)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultSemiAdaptiveInterval
+	}
+	r := &rebuilder{initial: c, opts: opts}
+	r.reset()
+	return r
+}
+
+// reset restores r to its initial state.
+func (r *rebuilder) reset() {
+	r.code = r.initial
+	r.n = 0
+	// Every symbol of the initial Code starts with a count of 1,
+	// so that it keeps a code after a rebuild.
+	r.cb = CodeBuilder{}
+	for i, bc := range r.initial.codes {
+		if bc.len != 0 {
+			r.cb.count(r.initial.symbol(i))
+		}
+	}
+}
+
+// add counts s. It reports whether it rebuilt r.code.
+func (r *rebuilder) add(s Symbol) (bool, error) {
+	r.cb.count(s)
+	if r.n++; r.n < r.opts.Interval {
+		return false, nil
+	}
+	r.n = 0
+	c, err := r.cb.CodeWithOptions(CodeOptions{MaxLen: r.opts.MaxLen, Escape: r.initial.escMode})
+	if err != nil {
+		return false, err
+	}
+	r.code = c
+	return true, nil
+}
+
+// A SemiAdaptiveEncoder is an [Encoder] whose [Code] changes as it encodes.
+// It starts with a given Code, and counts the symbols it writes.
+// After every Interval symbols, it rebuilds its Code from the counts.
+// A [SemiAdaptiveDecoder] does the same, so no Codes need to be transmitted.
+//
+// A SemiAdaptiveEncoder adapts to its input more slowly than an [AdaptiveEncoder],
+// but its output can be decoded with the faster table-driven method of a [Decoder].
+//
+// Symbols that have no code in the initial Code, and that have not yet been written,
+// must be written with an escape code. See [CodeOptions.Escape].
+//
+// Errors are sticky, as with an Encoder.
+type SemiAdaptiveEncoder struct {
+	enc *Encoder
+	rb  *rebuilder
+	sp  *splitter // nil if there is no SplitFunc
+}
+
+// NewSemiAdaptiveEncoder returns a [SemiAdaptiveEncoder] that writes to w, starting with c.
+// If c is nil, it starts with a Code in which every byte has an 8-bit code.
+// If split is nil, each byte written with [SemiAdaptiveEncoder.Write] is a symbol.
+func NewSemiAdaptiveEncoder(w io.Writer, c *Code, split SplitFunc, opts SemiAdaptiveOptions) *SemiAdaptiveEncoder {
+	rb := newRebuilder(c, opts)
+	e := &SemiAdaptiveEncoder{enc: rb.code.NewEncoder(w, nil), rb: rb}
+	if split != nil {
+		e.sp = &splitter{split: split}
+	}
+	return e
+}
+
+// Write encodes data, like [Encoder.Write].
+func (e *SemiAdaptiveEncoder) Write(data []byte) (int, error) {
+	if e.sp == nil {
+		return writeBytes(data, e.enc.bw, e.WriteSymbol)
+	}
+	return writeSplit(data, e.sp, &e.enc.err, e.enc.bw, e.WriteSymbols)
+}
+
+// WriteSymbol writes a symbol to the encoder, like [Encoder.WriteSymbol].
+func (e *SemiAdaptiveEncoder) WriteSymbol(s Symbol) error {
+	if err := e.enc.WriteSymbol(s); err != nil {
+		return err
+	}
+	rebuilt, err := e.rb.add(s)
+	if err != nil {
+		e.enc.err = err
+		return err
+	}
+	if rebuilt {
+		e.enc.c = e.rb.code
+	}
+	return nil
+}
+
+// WriteSymbols calls [SemiAdaptiveEncoder.WriteSymbol] for each symbol.
+func (e *SemiAdaptiveEncoder) WriteSymbols(syms []Symbol) error {
+	return writeSymbols(syms, e.WriteSymbol)
+}
+
+// Close passes any unconsumed bytes to the SplitFunc, with atEOF set to true,
+// and writes remaining data to the encoder's writer.
+func (e *SemiAdaptiveEncoder) Close() error {
+	return closeEncoder(e.sp, &e.enc.err, e.enc.bw, e.WriteSymbols)
+}
+
+// A SemiAdaptiveDecoder decodes data encoded by a [SemiAdaptiveEncoder].
+// Use [SemiAdaptiveDecoder.Decode] to decode all the data at once, or call [SemiAdaptiveDecoder.Reset]
+// and then [SemiAdaptiveDecoder.ReadSymbol] to decode a symbol at a time.
+type SemiAdaptiveDecoder struct {
+	dec     *Decoder
+	rb      *rebuilder
+	initial *table // the table for the initial Code
+}
+
+// NewSemiAdaptiveDecoder returns a [SemiAdaptiveDecoder].
+// Its arguments must be the same as those passed to [NewSemiAdaptiveEncoder].
+func NewSemiAdaptiveDecoder(c *Code, opts SemiAdaptiveOptions) *SemiAdaptiveDecoder {
+	rb := newRebuilder(c, opts)
+	dec := rb.code.NewDecoder()
+	return &SemiAdaptiveDecoder{dec: dec, rb: rb, initial: dec.table}
+}
+
+// Reset prepares d to decode the data in r with [SemiAdaptiveDecoder.ReadSymbol].
+// Decoding starts afresh, with the initial Code.
+func (d *SemiAdaptiveDecoder) Reset(r io.Reader) {
+	d.rb.reset()
+	d.dec.table = d.initial
+	d.dec.Reset(r)
+}
+
+// ReadSymbol decodes and returns the next symbol from the reader passed to [SemiAdaptiveDecoder.Reset].
+// At the end of the data, it returns io.EOF.
+func (d *SemiAdaptiveDecoder) ReadSymbol() (Symbol, error) {
+	if d.dec.br == nil {
+		return 0, errors.New("huffman.SemiAdaptiveDecoder.ReadSymbol: no reader; call Reset")
+	}
+	s, err := d.dec.ReadSymbol()
+	if err != nil {
+		return 0, err
+	}
+	rebuilt, err := d.rb.add(s)
+	if err != nil {
+		return 0, err
+	}
+	if rebuilt {
+		c := d.rb.code
+		d.dec.table = c.decodingTable(0)
+	}
+	return s, nil
+}
+
+// Decode decodes all the data in r, which must have been produced by a [SemiAdaptiveEncoder].
+func (d *SemiAdaptiveDecoder) Decode(r io.Reader) ([]Symbol, error) {
+	return decodeAll(readSymbols(r, d.Reset, d.ReadSymbol))
+}
@@ -0,0 +1,118 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSemiAdaptiveRoundTrip(t *testing.T) {
+	pride, err := os.ReadFile(filepath.Join("testdata", "pride-and-prejudice.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]Symbol, len(pride))
+	for i, b := range pride {
+		want[i] = Symbol(b)
+	}
+	dec := NewSemiAdaptiveDecoder(nil, SemiAdaptiveOptions{Interval: 100})
+	for _, opts := range []SemiAdaptiveOptions{
+		{Interval: 1},
+		{Interval: 100},
+		{Interval: 100, MaxLen: 9},
+		{},
+	} {
+		var buf bytes.Buffer
+		enc := NewSemiAdaptiveEncoder(&buf, nil, nil, opts)
+		// Write in pieces, to exercise rebuilding within a Write.
+		for in := pride; len(in) > 0; in = in[min(1000, len(in)):] {
+			if _, err := enc.Write(in[:min(1000, len(in))]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%+v: %d bytes", opts, buf.Len())
+		if opts.Interval == 100 && buf.Len() > len(pride)*3/4 {
+			t.Errorf("%+v: compressed %d bytes to %d", opts, len(pride), buf.Len())
+		}
+		if opts.MaxLen != 0 && enc.rb.code.MaxLen() > opts.MaxLen {
+			t.Errorf("%+v: final code has length %d", opts, enc.rb.code.MaxLen())
+		}
+		d := dec
+		if opts != dec.rb.opts {
+			d = NewSemiAdaptiveDecoder(nil, opts)
+		}
+		got, err := d.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%+v: decoded data differs", opts)
+		}
+	}
+}
+
+func TestSemiAdaptiveEscape(t *testing.T) {
+	// Start with a code for a few symbols, and an escape.
+	code, err := NewCodeWithOptions([]int{'a': 10, 'b': 5}, CodeOptions{Escape: EscapeGamma})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := SemiAdaptiveOptions{Interval: 10}
+	text := "abracadabra, alakazam! €100"
+	text += text
+	var input []Symbol
+	for _, r := range text {
+		input = append(input, Symbol(r))
+	}
+	var buf bytes.Buffer
+	enc := NewSemiAdaptiveEncoder(&buf, code, Runes(RejectInvalid).Split, opts)
+	if _, err := io.WriteString(enc, text); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Symbols that were escaped now have codes.
+	if c := enc.rb.code; c.code('r').len == 0 || c.code('€').len == 0 || c.escMode != EscapeGamma {
+		t.Error("rebuilt code is missing symbols or the escape")
+	}
+
+	dec := NewSemiAdaptiveDecoder(code, opts)
+	if _, err := dec.ReadSymbol(); err == nil {
+		t.Error("ReadSymbol before Reset: got nil, want error")
+	}
+	for range 2 { // check that Reset starts over
+		got, err := dec.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, input) {
+			t.Errorf("got %v, want %v", got, input)
+		}
+	}
+
+	// Without an escape, unknown symbols are errors.
+	code, err = NewCode([]int{'a': 10, 'b': 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc = NewSemiAdaptiveEncoder(io.Discard, code, nil, opts)
+	var serr *SymbolError
+	if _, err := enc.Write([]byte("abc")); !errors.As(err, &serr) || serr.Symbol != 'c' || serr.Pos != 2 {
+		t.Errorf("got %v, want SymbolError for 'c' at 2", err)
+	}
+	if err := enc.Close(); err != serr {
+		t.Errorf("Close: got %v, want %v", err, serr)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"errors"
+	"slices"
+)
+
+// sparseThreshold is the smallest symbol that makes a [CodeBuilder] or [ParseCode]
+// construct a sparse Code.
+const sparseThreshold = 1 << 20
+
+// NewSparseCode constructs a sparse [Code] for symbols with the given frequencies.
+// Symbols that are not in the map, or whose frequency is 0, have no code.
+// The Code is the same as the one [NewCode] would construct for the equivalent
+// slice of frequencies, but its memory is proportional to the size of the map
+// rather than to its largest Symbol.
+func NewSparseCode(frequencies map[Symbol]int) (*Code, error) {
+	return NewSparseCodeWithOptions(frequencies, CodeOptions{})
+}
+
+// NewSparseCodeWithOptions is like [NewSparseCode], but takes options.
+func NewSparseCodeWithOptions(frequencies map[Symbol]int, opts CodeOptions) (*Code, error) {
+	syms := make([]Symbol, 0, len(frequencies))
+	for s, f := range frequencies {
+		if f < 0 {
+			return nil, errors.New("huffman.NewCode: negative frequency")
+		}
+		if f > 0 {
+			syms = append(syms, s)
+		}
+	}
+	slices.Sort(syms)
+	// Construct a dense Code for the symbols' indexes in syms.
+	// Since syms is sorted, canonical values are assigned in the same order.
+	freqs := make([]int, len(syms))
+	for i, s := range syms {
+		freqs[i] = frequencies[s]
+	}
+	c, err := NewCodeWithOptions(freqs, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.syms = syms
+	return c, nil
+}
@@ -0,0 +1,215 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestSparseCodeMatchesDense(t *testing.T) {
+	freqs := []int{5, 0, 3, 3, 0, 0, 9, 1, 1, 0, 2, 7}
+	m := map[Symbol]int{4: 0} // zero frequencies are ignored
+	for s, f := range freqs {
+		if f > 0 {
+			m[Symbol(s)] = f
+		}
+	}
+	for _, opts := range []CodeOptions{{}, {MaxLen: 3}, {Escape: EscapeGamma}} {
+		dense, err := NewCodeWithOptions(freqs, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sparse, err := NewSparseCodeWithOptions(m, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for s := range Symbol(len(freqs) + 1) {
+			if g, w := sparse.code(s), dense.code(s); g != w {
+				t.Errorf("%+v: symbol %d: got %v, want %v", opts, s, g, w)
+			}
+		}
+		if sparse.esc != dense.esc {
+			t.Errorf("%+v: escape: got %v, want %v", opts, sparse.esc, dense.esc)
+		}
+		if g, w := sparse.String(), dense.String(); g != w {
+			t.Errorf("%+v: got\n%s\nwant\n%s", opts, g, w)
+		}
+		if !slices.Equal(sparse.Lengths(), dense.Lengths()) {
+			t.Errorf("%+v: Lengths differ", opts)
+		}
+		if !maps.Equal(maps.Collect(sparse.SymbolLengths()), maps.Collect(dense.SymbolLengths())) {
+			t.Errorf("%+v: SymbolLengths differ", opts)
+		}
+	}
+
+	if _, err := NewSparseCode(map[Symbol]int{1: -1}); err == nil {
+		t.Error("negative frequency: got nil, want error")
+	}
+}
+
+func TestSparseCode(t *testing.T) {
+	freqs := map[Symbol]int{
+		0:              5,
+		1000:           2,
+		1 << 31:        3,
+		math.MaxUint32: 1,
+	}
+	input := []Symbol{math.MaxUint32, 0, 1 << 31, 1000, 0, 1 << 31, 0}
+	for _, opts := range []CodeOptions{{}, {Escape: EscapeFixed}, {Escape: EscapeGamma}} {
+		code, err := NewSparseCodeWithOptions(freqs, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code.codes) != len(freqs) {
+			t.Fatalf("got %d codes, want %d", len(code.codes), len(freqs))
+		}
+		syms := input
+		if opts.Escape != NoEscape {
+			syms = append(slices.Clip(syms), 7, 1<<30, math.MaxUint32-1)
+		}
+		checkSparseRoundTrip(t, code, syms)
+
+		var gotSyms []Symbol
+		for s, l := range code.SymbolLengths() {
+			if w := int(code.code(s).len); l != w {
+				t.Errorf("%+v: SymbolLengths: symbol %d has length %d, want %d", opts, s, l, w)
+			}
+			gotSyms = append(gotSyms, s)
+		}
+		if want := slices.Sorted(maps.Keys(freqs)); !slices.Equal(gotSyms, want) {
+			t.Errorf("%+v: SymbolLengths: got symbols %v, want %v", opts, gotSyms, want)
+		}
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%+v: Lengths did not panic", opts)
+				}
+			}()
+			code.Lengths()
+		}()
+
+		// Marshaling.
+		got, err := UnmarshalCode(code.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got.syms, code.syms) || !slices.Equal(got.codes, code.codes) ||
+			got.esc != code.esc || got.escMode != code.escMode {
+			t.Errorf("%+v: unmarshaled code differs:\n%v\nwant\n%v", opts, got, code)
+		}
+
+		// Text form.
+		got, err = ParseCode(code.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got.syms, code.syms) || got.String() != code.String() {
+			t.Errorf("%+v: parsed code differs:\n%v\nwant\n%v", opts, got, code)
+		}
+	}
+
+	// An empty sparse code survives marshaling.
+	code, err := NewSparseCode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnmarshalCode(code.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.syms == nil || len(got.codes) != 0 {
+		t.Errorf("got %#v, want empty sparse code", got)
+	}
+}
+
+func checkSparseRoundTrip(t *testing.T, code *Code, syms []Symbol) {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := code.NewEncoder(&buf, nil)
+	if err := enc.WriteSymbols(syms); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := code.NewDecoder().Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, syms) {
+		t.Errorf("got %v, want %v", got, syms)
+	}
+}
+
+func TestCodeBuilderSparse(t *testing.T) {
+	var input []byte
+	var syms []Symbol
+	for i := range 1000 {
+		s := Symbol(i % 7)
+		if i%3 == 0 {
+			s = Symbol(i%11) << 28
+		}
+		syms = append(syms, s)
+		input = binary.BigEndian.AppendUint32(input, s)
+	}
+	cb := Uint32s(binary.BigEndian).NewCodeBuilder()
+	cb.Write(input[:8]) // small symbols only
+	if cb.sparse != nil {
+		t.Fatal("sparse too soon")
+	}
+	cb.Write(input[8:])
+	if cb.sparse == nil {
+		t.Fatal("not sparse")
+	}
+	code, err := cb.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code.syms == nil {
+		t.Fatal("code is not sparse")
+	}
+	if len(code.codes) > 20 {
+		t.Errorf("got %d codes, want at most 20", len(code.codes))
+	}
+	checkSparseRoundTrip(t, code, syms)
+
+	st, err := cb.Stats(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Symbols != len(syms) {
+		t.Errorf("Stats: got %d symbols, want %d", st.Symbols, len(syms))
+	}
+}
+
+func TestUnmarshalSparseCodeErrors(t *testing.T) {
+	const hdr = marshalMagic | marshalSparse
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"no count", []byte{hdr}},
+		{"too many symbols", []byte{hdr, 5, 1}},
+		{"bad gap", []byte{hdr, 1, 0x80}},
+		// 2^32-1, then a symbol after it.
+		{"out of range", []byte{hdr, 2, 0xff, 0xff, 0xff, 0xff, 0x0f, 0, 0b01_0000_01}},
+		{"too few lengths", []byte{hdr, 2, 3, 4, 0b00_0000_01}},
+		{"too many lengths", []byte{hdr, 1, 3, 0b01_0000_01}},
+		{"zero length", []byte{hdr, 2, 3, 4, 0b00_0000_01, 0b0}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := UnmarshalCode(tc.data); err == nil {
+				t.Error("got nil, want error")
+			} else {
+				t.Log(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// This file defines Tokenizers for common kinds of symbols.
+
+// InvalidUTF8 is a policy for handling invalid UTF-8 in the input to a [Runes] Tokenizer.
+type InvalidUTF8 int
+
+const (
+	// ReplaceInvalid splits each invalid byte into the symbol for U+FFFD,
+	// the Unicode replacement character. The original bytes are lost.
+	ReplaceInvalid InvalidUTF8 = iota
+
+	// RejectInvalid causes splitting to fail on invalid UTF-8.
+	RejectInvalid
+
+	// EscapeInvalid splits each invalid byte b into the symbol
+	// utf8.MaxRune + 1 + b, outside the range of code points.
+	// Joining restores the original bytes.
+	EscapeInvalid
+)
+
+// Runes returns a [Tokenizer] whose symbols are Unicode code points,
+// encoded in UTF-8. Invalid UTF-8 is handled according to invalid.
+func Runes(invalid InvalidUTF8) Tokenizer {
+	const escapeBase = utf8.MaxRune + 1
+	split := func(data []byte, atEOF bool) (int, []Symbol, error) {
+		var syms []Symbol
+		i := 0
+		for i < len(data) {
+			if !atEOF && !utf8.FullRune(data[i:]) {
+				break
+			}
+			r, size := utf8.DecodeRune(data[i:])
+			if r == utf8.RuneError && size == 1 {
+				switch invalid {
+				case RejectInvalid:
+					return i, syms, fmt.Errorf("huffman.Runes: invalid UTF-8 byte 0x%02x", data[i])
+				case EscapeInvalid:
+					r = rune(escapeBase + int(data[i]))
+				}
+			}
+			syms = append(syms, Symbol(r))
+			i += size
+		}
+		return i, syms, nil
+	}
+	join := func(dst []byte, syms []Symbol) ([]byte, error) {
+		for _, s := range syms {
+			switch {
+			case s <= utf8.MaxRune && utf8.ValidRune(rune(s)):
+				dst = utf8.AppendRune(dst, rune(s))
+			case invalid == EscapeInvalid && s >= escapeBase && s <= escapeBase+255:
+				dst = append(dst, byte(s-escapeBase))
+			default:
+				return dst, fmt.Errorf("huffman.Runes: symbol %d is not a valid rune", s)
+			}
+		}
+		return dst, nil
+	}
+	return Tokenizer{Split: split, Join: join}
+}
+
+// Uint16s returns a [Tokenizer] whose symbols are unsigned 16-bit integers,
+// each represented by two bytes in the given byte order.
+// The input must consist of a whole number of integers.
+func Uint16s(order binary.ByteOrder) Tokenizer {
+	return fixedWidth(2, func(b []byte) Symbol { return Symbol(order.Uint16(b)) },
+		func(b []byte, s Symbol) { order.PutUint16(b, uint16(s)) })
+}
+
+// Uint32s returns a [Tokenizer] whose symbols are unsigned 32-bit integers,
+// each represented by four bytes in the given byte order.
+// The input must consist of a whole number of integers.
+func Uint32s(order binary.ByteOrder) Tokenizer {
+	return fixedWidth(4, func(b []byte) Symbol { return Symbol(order.Uint32(b)) },
+		func(b []byte, s Symbol) { order.PutUint32(b, s) })
+}
+
+// fixedWidth returns a Tokenizer for symbols of width bytes,
+// which are read by get and written by put.
+func fixedWidth(width int, get func([]byte) Symbol, put func([]byte, Symbol)) Tokenizer {
+	maxSym := Symbol(math.MaxUint32 >> (32 - 8*width))
+	split := func(data []byte, atEOF bool) (int, []Symbol, error) {
+		n := len(data) / width * width
+		if atEOF && n < len(data) {
+			return 0, nil, fmt.Errorf("huffman: %d extra bytes at end of %d-byte integers", len(data)-n, width)
+		}
+		syms := make([]Symbol, n/width)
+		for i := range syms {
+			syms[i] = get(data[i*width:])
+		}
+		return n, syms, nil
+	}
+	join := func(dst []byte, syms []Symbol) ([]byte, error) {
+		var buf [4]byte
+		for _, s := range syms {
+			if s > maxSym {
+				return dst, fmt.Errorf("huffman: symbol %d does not fit in %d bytes", s, width)
+			}
+			put(buf[:width], s)
+			dst = append(dst, buf[:width]...)
+		}
+		return dst, nil
+	}
+	return Tokenizer{Split: split, Join: join}
+}
+
+// Uvarints returns a [Tokenizer] whose symbols are unsigned integers
+// encoded as varints, as by [binary.AppendUvarint].
+// The integers must fit in 32 bits.
+func Uvarints() Tokenizer {
+	split := func(data []byte, atEOF bool) (int, []Symbol, error) {
+		var syms []Symbol
+		i := 0
+		for i < len(data) {
+			v, n := binary.Uvarint(data[i:])
+			if n == 0 {
+				// Incomplete varint.
+				if atEOF {
+					return i, syms, errors.New("huffman.Uvarints: incomplete varint at end of input")
+				}
+				break
+			}
+			if n < 0 || v > math.MaxUint32 {
+				return i, syms, errors.New("huffman.Uvarints: varint overflows 32 bits")
+			}
+			syms = append(syms, Symbol(v))
+			i += n
+		}
+		return i, syms, nil
+	}
+	join := func(dst []byte, syms []Symbol) ([]byte, error) {
+		for _, s := range syms {
+			dst = binary.AppendUvarint(dst, uint64(s))
+		}
+		return dst, nil
+	}
+	return Tokenizer{Split: split, Join: join}
+}
+
+// Words returns a [Tokenizer] that splits its input into words and whitespace.
+// Each maximal run of ASCII whitespace, and each maximal run of other bytes,
+// is a token. Joining the tokens restores the input exactly.
+//
+// The Tokenizer assigns a symbol to each distinct token as it encounters it,
+// so the same Tokenizer must be used to build a Code and to encode and decode
+// with it. It is not safe for concurrent use.
+// To control the assignment of symbols, use [Alphabet.Words].
+func Words() Tokenizer {
+	return NewAlphabet().Words()
+}
+
+// Words is like the function [Words], but uses a to assign symbols to tokens.
+// Since splitting adds new tokens to a, the same Alphabet must
+// be used to build a Code and to encode and decode with it.
+func (a *Alphabet) Words() Tokenizer {
+	return a.Tokenizer(splitWords)
+}
+
+// splitWords is a TokenSplitFunc for [Words].
+func splitWords(data []byte, atEOF bool) (int, []string, error) {
+	var toks []string
+	start := 0
+	for start < len(data) {
+		sp := isSpace(data[start])
+		end := start + 1
+		for end < len(data) && isSpace(data[end]) == sp {
+			end++
+		}
+		if end == len(data) && !atEOF {
+			// The token may continue in the next write.
+			break
+		}
+		toks = append(toks, string(data[start:end]))
+		start = end
+	}
+	return start, toks, nil
+}
+
+func isSpace(b byte) bool {
+	switch b {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
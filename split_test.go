@@ -0,0 +1,145 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestBuiltinTokenizers(t *testing.T) {
+	pride, err := os.ReadFile(filepath.Join("testdata", "pride-and-prejudice.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := []byte("Ünïcödé ☃ text, 日本語, and emoji 🎉🎉.")
+
+	var uvarints, le32, be32 []byte
+	for i := range 2000 {
+		v := uint32(i * i % 100_003)
+		uvarints = binary.AppendUvarint(uvarints, uint64(v))
+		le32 = binary.LittleEndian.AppendUint32(le32, v)
+		be32 = binary.BigEndian.AppendUint32(be32, v)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		tok   Tokenizer
+		input []byte
+	}{
+		{"runes", Runes(RejectInvalid), text},
+		{"runes_pride", Runes(RejectInvalid), pride},
+		{"runes_escape", Runes(EscapeInvalid), append([]byte("ab\xffc\xe2\x98"), text...)},
+		{"uint16_le", Uint16s(binary.LittleEndian), pride[:len(pride)&^1]},
+		{"uint16_be", Uint16s(binary.BigEndian), pride[:len(pride)&^1]},
+		{"uint32_le", Uint32s(binary.LittleEndian), le32},
+		{"uint32_be", Uint32s(binary.BigEndian), be32},
+		{"uvarints", Uvarints(), uvarints},
+		{"words", Words(), pride},
+		{"words_spaces", Words(), []byte("  leading and trailing\t\tspace \n")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testTokenizerRoundTrip(t, tc.input, tc.tok)
+		})
+	}
+}
+
+func TestRunesReplaceInvalid(t *testing.T) {
+	tok := Runes(ReplaceInvalid)
+	input := []byte("a\xffb\xe2\x98")
+	cb := tok.NewCodeBuilder()
+	cb.Write(input)
+	code, err := cb.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc := tok.NewEncoder(code, &buf)
+	enc.Write(input)
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := tok.NewDecoder(code).DecodeTo(&out, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "a�b��"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTokenizerErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		tok   Tokenizer
+		input []byte
+	}{
+		{"invalid_utf8", Runes(RejectInvalid), []byte("ab\xffc")},
+		{"incomplete_rune", Runes(RejectInvalid), []byte("ab\xe2\x98")},
+		{"odd_uint16", Uint16s(binary.LittleEndian), []byte{1, 2, 3}},
+		{"partial_uint32", Uint32s(binary.BigEndian), []byte{1, 2, 3, 4, 5, 6}},
+		{"incomplete_uvarint", Uvarints(), []byte{1, 0x80}},
+		{"large_uvarint", Uvarints(), binary.AppendUvarint(nil, 1<<32)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cb := tc.tok.NewCodeBuilder()
+			_, err := cb.Write(tc.input)
+			if err == nil {
+				_, err = cb.Code()
+			}
+			if err == nil {
+				t.Error("got nil, want error")
+			}
+		})
+	}
+
+	// Join errors.
+	for _, tc := range []struct {
+		name string
+		tok  Tokenizer
+		syms []Symbol
+	}{
+		{"surrogate", Runes(EscapeInvalid), []Symbol{0xD800}},
+		{"not_escaped", Runes(RejectInvalid), []Symbol{0x110000}},
+		{"big_uint16", Uint16s(binary.BigEndian), []Symbol{1 << 16}},
+		{"unknown_word", Words(), []Symbol{0}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.tok.Join(nil, tc.syms); err == nil {
+				t.Error("got nil, want error")
+			}
+		})
+	}
+}
+
+func TestWordsSymbols(t *testing.T) {
+	a := NewAlphabet()
+	tok := a.Words()
+	var syms []Symbol
+	cb := NewCodeBuilder(func(data []byte, atEOF bool) (int, []Symbol, error) {
+		n, ss, err := tok.Split(data, atEOF)
+		syms = append(syms, ss...)
+		return n, ss, err
+	})
+	io.WriteString(cb, "the cat ")
+	io.WriteString(cb, "and the ca")
+	io.WriteString(cb, "t")
+	if _, err := cb.Code(); err != nil {
+		t.Fatal(err)
+	}
+	// Tokens: "the" " " "cat" " " "and" " " "the" " " "cat"
+	want := []Symbol{0, 1, 2, 1, 3, 1, 0, 1, 2}
+	if !slices.Equal(syms, want) {
+		t.Errorf("got %v, want %v", syms, want)
+	}
+	if tok, _ := a.Token(2); tok != "cat" {
+		t.Errorf("Token(2) = %q, want %q", tok, "cat")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"iter"
+	"math"
+)
+
+// Stats describes how well a [Code] encodes symbols with given frequencies.
+type Stats struct {
+	// Symbols is the number of symbols: the sum of the frequencies.
+	Symbols int
+
+	// Entropy is the Shannon entropy of the frequencies, in bits per symbol.
+	// No code that encodes each symbol separately can do better on average.
+	Entropy float64
+
+	// AverageLength is the average number of bits per symbol
+	// used to encode the symbols.
+	AverageLength float64
+
+	// Redundancy is AverageLength minus Entropy. For a Huffman code
+	// constructed from the same frequencies, it is less than 1.
+	Redundancy float64
+
+	// EncodedBits is the number of bits needed to encode the symbols,
+	// as reported by [Code.EncodedBits].
+	EncodedBits int
+
+	// MarshaledSize is the length of the result of [Code.Marshal].
+	MarshaledSize int
+}
+
+// Stats returns statistics about using c to encode symbols with the
+// given frequencies, where frequencies[i] is the frequency of Symbol(i).
+// It returns an error if a frequency is negative, or if a symbol with a
+// nonzero frequency cannot be encoded.
+func (c *Code) Stats(frequencies []int) (Stats, error) {
+	return c.stats(denseFrequencies(frequencies))
+}
+
+func (c *Code) stats(frequencies iter.Seq2[Symbol, int]) (Stats, error) {
+	bits, err := c.encodedBits(frequencies)
+	if err != nil {
+		return Stats{}, err
+	}
+	st := Stats{
+		Entropy:       entropy(frequencies),
+		EncodedBits:   bits,
+		MarshaledSize: len(c.Marshal()),
+	}
+	for _, f := range frequencies {
+		st.Symbols += f
+	}
+	if st.Symbols > 0 {
+		st.AverageLength = float64(bits) / float64(st.Symbols)
+		st.Redundancy = st.AverageLength - st.Entropy
+	}
+	return st, nil
+}
+
+// Stats returns statistics about using c to encode the symbols written to cb.
+// If c is nil, it uses the Code that cb would construct.
+// Like [CodeBuilder.Code], it first passes any unconsumed bytes to the SplitFunc.
+func (cb *CodeBuilder) Stats(c *Code) (Stats, error) {
+	if c == nil {
+		var err error
+		c, err = cb.Code()
+		if err != nil {
+			return Stats{}, err
+		}
+	} else if err := cb.flush(); err != nil {
+		return Stats{}, err
+	}
+	return c.stats(cb.frequencies())
+}
+
+// Entropy returns the Shannon entropy of the frequencies, in bits per symbol.
+// It is zero if all the frequencies are zero. Negative frequencies are ignored.
+func Entropy(frequencies []int) float64 {
+	return entropy(denseFrequencies(frequencies))
+}
+
+func entropy(frequencies iter.Seq2[Symbol, int]) float64 {
+	// With n the sum of the frequencies, the entropy is
+	//   sum(-f/n * log2(f/n)) = log2(n) - sum(f * log2(f)) / n.
+	n := 0
+	var sum float64
+	for _, f := range frequencies {
+		if f > 0 {
+			n += f
+			sum += float64(f) * math.Log2(float64(f))
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return max(math.Log2(float64(n))-sum/float64(n), 0)
+}
@@ -0,0 +1,109 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEntropy(t *testing.T) {
+	for _, tc := range []struct {
+		freqs []int
+		want  float64
+	}{
+		{nil, 0},
+		{[]int{0, 0}, 0},
+		{[]int{5}, 0},
+		{[]int{1, 1}, 1},
+		{[]int{3, 0, 3, 3, 3}, 2},
+		{[]int{2, 1, 1}, 1.5},
+		{[]int{1, 3}, 2 - 0.75*math.Log2(3)},
+	} {
+		if got := Entropy(tc.freqs); math.Abs(got-tc.want) > 1e-12 {
+			t.Errorf("Entropy(%v) = %g, want %g", tc.freqs, got, tc.want)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	// Dyadic frequencies have a code with no redundancy.
+	code, err := NewCode([]int{4, 2, 1, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := code.Stats([]int{4, 2, 1, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{
+		Symbols:       8,
+		Entropy:       1.75,
+		AverageLength: 1.75,
+		EncodedBits:   14,
+		MarshaledSize: len(code.Marshal()),
+	}
+	if st != want {
+		t.Errorf("got %+v, want %+v", st, want)
+	}
+	if _, err := code.Stats([]int{1, 1, 1, 1, 1}); err == nil {
+		t.Error("symbol with no code: got nil, want error")
+	}
+	if _, err := code.Stats([]int{-5, 1}); err == nil {
+		t.Error("Stats with negative frequency: got nil, want error")
+	}
+	if _, err := code.EncodedBits([]int{-5, 1}); err == nil {
+		t.Error("EncodedBits with negative frequency: got nil, want error")
+	}
+
+	pride, err := os.ReadFile(filepath.Join("testdata", "pride-and-prejudice.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := NewCodeBuilder(nil)
+	cb.Write(pride)
+	st, err = cb.Stats(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%+v", st)
+	if st.Symbols != len(pride) || st.Redundancy < 0 || st.Redundancy >= 1 {
+		t.Errorf("bad stats %+v", st)
+	}
+	code, err = cb.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// EncodedBits matches the output of an Encoder.
+	var buf bytes.Buffer
+	enc := code.NewEncoder(&buf, nil)
+	enc.Write(pride)
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.Len()-1, (st.EncodedBits+7)/8; got != want {
+		t.Errorf("encoded %d bytes, want %d", got, want)
+	}
+
+	// A worse code has more redundancy.
+	flat := make([]uint8, 256)
+	for i := range flat {
+		flat[i] = 8
+	}
+	flatCode, err := NewCodeFromLengths(flat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st2, err := cb.Stats(flatCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st2.AverageLength != 8 || st2.Redundancy <= st.Redundancy || st2.Entropy != st.Entropy {
+		t.Errorf("flat code: got %+v", st2)
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A stream written by a [Writer] consists of the magic string streamMagic,
+// whose last byte is a version number, followed by a sequence of blocks.
+// Each block begins with a byte giving its kind:
+//   - blockEnd: the end of the stream; nothing follows.
+//   - blockNewCode: the length of a marshaled Code as a uvarint, the marshaled Code,
+//     then the length of the payload as a uvarint, and the payload.
+//   - blockSameCode: the length of the payload as a uvarint, and the payload,
+//     which uses the Code of the previous block.
+//
+// The payload is the output of an Encoder for the block's Code.
+const streamMagic = "HUF\x01"
+
+const (
+	blockEnd = iota
+	blockNewCode
+	blockSameCode
+)
+
+// maxStreamCodeLen bounds the size of the marshaled Code in a stream.
+// Stream codes are for bytes, so their marshaled forms are small.
+const maxStreamCodeLen = 1 << 12
+
+// defaultBlockSize is the block size used by [NewWriter].
+const defaultBlockSize = 128 << 10
+
+// A Writer is an [io.WriteCloser] that compresses the bytes written to it.
+// It writes the compressed data, along with the [Code]s needed to decompress it,
+// to an underlying [io.Writer]. Use a [Reader] to decompress the data.
+//
+// A Writer divides its input into blocks. It buffers each block
+// and builds a Code from it, so that the Code adapts to changes in the input.
+// If the Code for the previous block compresses the current one
+// at least as well, the Writer uses it instead of writing a new one.
+type Writer struct {
+	w         io.Writer
+	blockSize int
+	buf       []byte       // the current block
+	prev      *Code        // the Code of the previous block
+	payload   bytes.Buffer // reused for encoding each block
+	started   bool         // the magic string has been written
+	err       error
+	closed    bool
+}
+
+// NewWriter returns a new [Writer] that writes to w, with a default block size.
+// It is the caller's responsibility to call Close on the Writer when done.
+func NewWriter(w io.Writer) *Writer {
+	return NewWriterSize(w, defaultBlockSize)
+}
+
+// NewWriterSize is like [NewWriter], but divides the input into blocks
+// of blockSize bytes. If blockSize is not positive, the default is used.
+// Larger blocks amortize the cost of writing the Code over more data,
+// but use more memory and adapt less quickly to changes in the input.
+func NewWriterSize(w io.Writer, blockSize int) *Writer {
+	if blockSize <= 0 {
+		blockSize = defaultBlockSize
+	}
+	return &Writer{w: w, blockSize: blockSize}
+}
+
+// Write compresses p, writing complete blocks to the underlying [io.Writer].
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("huffman.Writer: write after Close")
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	n := 0
+	for len(p) > 0 {
+		if z.buf == nil {
+			z.buf = make([]byte, 0, z.blockSize)
+		}
+		k := min(len(p), z.blockSize-len(z.buf))
+		z.buf = append(z.buf, p[:k]...)
+		p = p[k:]
+		n += k
+		if len(z.buf) == z.blockSize {
+			if z.err = z.writeBlock(); z.err != nil {
+				return n, z.err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close writes any remaining data and the end of the stream to the underlying [io.Writer].
+// It does not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err != nil {
+		return z.err
+	}
+	if len(z.buf) > 0 {
+		if z.err = z.writeBlock(); z.err != nil {
+			return z.err
+		}
+	}
+	_, z.err = z.w.Write(append(z.header(), blockEnd))
+	return z.err
+}
+
+// header returns the magic string if it has not yet been written, and nil otherwise.
+func (z *Writer) header() []byte {
+	if z.started {
+		return nil
+	}
+	z.started = true
+	return []byte(streamMagic)
+}
+
+// writeBlock compresses and writes the buffered block.
+func (z *Writer) writeBlock() error {
+	cb := NewCodeBuilder(nil)
+	cb.Write(z.buf)
+	code, err := cb.Code()
+	if err != nil {
+		return err
+	}
+	mc := code.Marshal()
+	// Reuse the previous Code if it costs no more than the new one plus its description.
+	hdr := append(z.header(), blockNewCode)
+	if z.prev != nil {
+		newBits, _ := code.EncodedBits(cb.freqs)
+		if prevBits, err := z.prev.EncodedBits(cb.freqs); err == nil && (prevBits+7)/8 <= (newBits+7)/8+len(mc) {
+			code = z.prev
+			hdr[len(hdr)-1] = blockSameCode
+		}
+	}
+	if hdr[len(hdr)-1] == blockNewCode {
+		hdr = binary.AppendUvarint(hdr, uint64(len(mc)))
+		hdr = append(hdr, mc...)
+	}
+	z.prev = code
+
+	z.payload.Reset()
+	enc := code.NewEncoder(&z.payload, nil)
+	enc.Write(z.buf)
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	z.buf = z.buf[:0]
+	hdr = binary.AppendUvarint(hdr, uint64(z.payload.Len()))
+	if _, err := z.w.Write(hdr); err != nil {
+		return err
+	}
+	_, err = z.w.Write(z.payload.Bytes())
+	return err
+}
+
+// A Reader is an [io.Reader] that decompresses data written by a [Writer].
+type Reader struct {
+	r     *bufio.Reader
+	code  *Code             // the Code of the current block
+	block *io.LimitedReader // the payload of the current block
+	dec   io.Reader         // decodes block
+	err   error
+}
+
+// NewReader returns a new [Reader] that decompresses the data in r.
+// It reads the stream header, returning an error if it is invalid.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	var magic [len(streamMagic)]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return nil, fmt.Errorf("huffman.NewReader: reading header: %w", err)
+	}
+	if string(magic[:]) != streamMagic {
+		return nil, errors.New("huffman.NewReader: bad magic number")
+	}
+	return &Reader{r: br}, nil
+}
+
+// Read reads decompressed data into p.
+func (z *Reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for z.err == nil {
+		if z.dec == nil {
+			z.err = z.startBlock()
+			continue
+		}
+		n, err := z.dec.Read(p)
+		if err == io.EOF {
+			z.dec = nil
+			err = nil
+			// The block's payload must be consumed exactly.
+			if z.block.N != 0 {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		z.err = err
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+	return 0, z.err
+}
+
+// startBlock reads the header of the next block and prepares to decode it.
+// At the end of the stream, it returns io.EOF.
+func (z *Reader) startBlock() error {
+	kind, err := z.r.ReadByte()
+	if err != nil {
+		return noEOF(err)
+	}
+	switch kind {
+	case blockEnd:
+		return io.EOF
+	case blockNewCode:
+		n, err := binary.ReadUvarint(z.r)
+		if err != nil {
+			return fmt.Errorf("huffman.Reader: reading code length: %w", noEOF(err))
+		}
+		if n > maxStreamCodeLen {
+			return fmt.Errorf("huffman.Reader: code length %d too large", n)
+		}
+		mc := make([]byte, n)
+		if _, err := io.ReadFull(z.r, mc); err != nil {
+			return fmt.Errorf("huffman.Reader: reading code: %w", noEOF(err))
+		}
+		z.code, err = UnmarshalCode(mc)
+		if err != nil {
+			return fmt.Errorf("huffman.Reader: %w", err)
+		}
+	case blockSameCode:
+		if z.code == nil {
+			return errors.New("huffman.Reader: first block has no code")
+		}
+	default:
+		return fmt.Errorf("huffman.Reader: bad block kind %d", kind)
+	}
+
+	n, err := binary.ReadUvarint(z.r)
+	if err != nil {
+		return fmt.Errorf("huffman.Reader: reading payload length: %w", noEOF(err))
+	}
+	z.block = &io.LimitedReader{R: z.r, N: int64(n)}
+	z.dec = z.code.NewReader(z.block)
+	return nil
+}
@@ -0,0 +1,184 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+	pride, err := os.ReadFile(filepath.Join("testdata", "pride-and-prejudice.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first half is mostly 'a' and the second mostly 'z'.
+	var shift []byte
+	for i := range 5000 {
+		b := byte('a')
+		if i >= 2500 {
+			b = 'z'
+		}
+		if i%5 == 0 {
+			b = byte('b' + i%20)
+		}
+		shift = append(shift, b)
+	}
+	for _, tc := range []struct {
+		name  string
+		input []byte
+	}{
+		{"empty", nil},
+		{"single_char", bytes.Repeat([]byte("x"), 100)},
+		{"short_string", []byte("a man a plan a canal panama")},
+		{"pride_and_prejudice", pride},
+		{"shift", shift},
+	} {
+		for _, blockSize := range []int{0, 1, 100, 1000} {
+			t.Run(fmt.Sprintf("%s-%d", tc.name, blockSize), func(t *testing.T) {
+				var buf bytes.Buffer
+				w := NewWriterSize(&buf, blockSize)
+				// Write in pieces.
+				for in := tc.input; len(in) > 0; {
+					n := min(len(in), 777)
+					if _, err := w.Write(in[:n]); err != nil {
+						t.Fatal(err)
+					}
+					in = in[n:]
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				t.Logf("%d bytes compressed to %d", len(tc.input), buf.Len())
+
+				r, err := NewReader(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, tc.input) {
+					t.Errorf("got %d bytes, want %d", len(got), len(tc.input))
+				}
+			})
+		}
+	}
+}
+
+func TestStreamReuseCode(t *testing.T) {
+	// Two identical blocks: the second should reuse the first's code.
+	block := []byte("a man a plan a canal panama")
+	var buf bytes.Buffer
+	w := NewWriterSize(&buf, len(block))
+	w.Write(block)
+	w.Write(block)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()[len(streamMagic):]
+	skip := func() {
+		n, k := binary.Uvarint(data)
+		data = data[k+int(n):]
+	}
+	if data[0] != blockNewCode {
+		t.Fatalf("first block kind: got %d, want %d", data[0], blockNewCode)
+	}
+	data = data[1:]
+	skip() // code
+	skip() // payload
+	if data[0] != blockSameCode {
+		t.Fatalf("second block kind: got %d, want %d", data[0], blockSameCode)
+	}
+	data = data[1:]
+	skip() // payload
+	if !bytes.Equal(data, []byte{blockEnd}) {
+		t.Fatalf("got %v at end, want end block", data)
+	}
+}
+
+func TestStreamReadEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	io.WriteString(w, "hello")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range [][]byte{nil, make([]byte, 8)[:0]} {
+		if n, err := r.Read(p); n != 0 || err != nil {
+			t.Errorf("Read(%v) = %d, %v; want 0, nil", p, n, err)
+		}
+	}
+	// The data is still there.
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("got %q, want %q", got, "hello")
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write([]byte("hello, world"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("x")); err == nil {
+		t.Error("Write after Close: got nil, want error")
+	}
+	good := buf.Bytes()
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", append([]byte("HUG"), good[3:]...)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewReader(bytes.NewReader(tc.data)); err == nil {
+				t.Error("got nil, want error")
+			}
+		})
+	}
+
+	magic := func(bs ...byte) []byte { return append([]byte(streamMagic), bs...) }
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"no end", magic()},
+		{"bad kind", magic(7)},
+		{"short header", good[:len(streamMagic)+2]},
+		{"bad code", magic(blockNewCode, 1, 0)},
+		{"long code", magic(blockNewCode, 0xff, 0xff, 0x03)},
+		{"no previous code", magic(blockSameCode, 1, 0, blockEnd)},
+		{"truncated payload", good[:len(good)-3]},
+		{"missing end", good[:len(good)-1]},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(tc.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(r); err == nil {
+				t.Error("got nil, want error")
+			}
+		})
+	}
+}
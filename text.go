@@ -0,0 +1,201 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The text form of a Code has one line for each symbol:
+//
+//	symbol length bits
+//
+// The symbol is a decimal number. The bits are written as 0s and 1s,
+// in the order they appear in the encoded data. A Code with an escape code has
+// a line for it whose symbol is "escape" or "escape-gamma", depending on the
+// escape mode. In the verbose form, symbols without a code have a length
+// of 0 and bits "-".
+
+const (
+	escapeFixedName = "escape"
+	escapeGammaName = "escape-gamma"
+)
+
+// String returns the text form of c, listing only the symbols that have codes.
+// See [Code.Format].
+func (c *Code) String() string {
+	return fmt.Sprint(c)
+}
+
+// Format implements [fmt.Formatter]. With the verbs %v and %s, it writes one line for each
+// symbol that has a code, giving the symbol, the length of its code, and its bits in the
+// order they are written. The flag '+' (as in %+v) also lists symbols without codes.
+// [ParseCode] parses the result.
+func (c *Code) Format(f fmt.State, verb rune) {
+	if verb != 'v' && verb != 's' {
+		fmt.Fprintf(f, "%%!%c(*huffman.Code)", verb)
+		return
+	}
+	all := f.Flag('+')
+	var b strings.Builder
+	for i, bc := range c.codes {
+		if bc.len != 0 || all {
+			writeCodeLine(&b, strconv.FormatUint(uint64(c.symbol(i)), 10), bc)
+		}
+	}
+	switch c.escMode {
+	case EscapeFixed:
+		writeCodeLine(&b, escapeFixedName, c.esc)
+	case EscapeGamma:
+		writeCodeLine(&b, escapeGammaName, c.esc)
+	}
+	f.Write([]byte(b.String()))
+}
+
+func writeCodeLine(b *strings.Builder, sym string, bc bitcode) {
+	fmt.Fprintf(b, "%s %d ", sym, bc.len)
+	if bc.len == 0 {
+		b.WriteByte('-')
+	}
+	for i := range bc.len {
+		b.WriteByte('0' + byte(bc.val>>i&1))
+	}
+	b.WriteByte('\n')
+}
+
+// ParseCode constructs a [Code] from its text form, as written by [Code.Format].
+// The codes need not be canonical or complete, but they must form a prefix code.
+// Since [Code.Marshal] represents only code lengths, a Code with non-canonical
+// codes does not survive marshaling.
+// Blank lines and lines beginning with '#' are ignored, and the length may be omitted.
+// A symbol may also be written as a Go character literal, like 'a' or ' '.
+// If a symbol is 2^20 or more, the Code is sparse (see [NewSparseCode]),
+// and symbols without codes are omitted.
+func ParseCode(text string) (*Code, error) {
+	c := &Code{tables: &tableCache{}}
+	codes := map[Symbol]bitcode{}
+	type entry struct {
+		bits string
+		line int
+	}
+	var entries []entry
+	for i, line := range strings.Split(text, "\n") {
+		lineno := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if line[0] == '\'' {
+			// The literal may contain whitespace, as in ' '.
+			q, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("huffman.ParseCode: line %d: bad character literal", lineno)
+			}
+			fields = append([]string{q}, strings.Fields(line[len(q):])...)
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("huffman.ParseCode: line %d: want 2 or 3 fields", lineno)
+		}
+		bits := fields[len(fields)-1]
+		if len(fields) == 3 {
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("huffman.ParseCode: line %d: bad length %q", lineno, fields[1])
+			}
+			if (n == 0) != (bits == "-") || (n > 0 && n != len(bits)) {
+				return nil, fmt.Errorf("huffman.ParseCode: line %d: length %d does not match bits %q", lineno, n, bits)
+			}
+		}
+		var bc bitcode
+		if bits != "-" {
+			if len(bits) > maxCodeLen {
+				return nil, fmt.Errorf("huffman.ParseCode: line %d: code longer than %d bits", lineno, maxCodeLen)
+			}
+			for j, r := range bits {
+				switch r {
+				case '0':
+				case '1':
+					bc.val |= 1 << j
+				default:
+					return nil, fmt.Errorf("huffman.ParseCode: line %d: bad bits %q", lineno, bits)
+				}
+			}
+			bc.len = uint32(len(bits))
+			entries = append(entries, entry{bits, lineno})
+		}
+
+		switch fields[0] {
+		case escapeFixedName, escapeGammaName:
+			if c.escMode != NoEscape {
+				return nil, fmt.Errorf("huffman.ParseCode: line %d: duplicate escape", lineno)
+			}
+			if bc.len == 0 {
+				return nil, fmt.Errorf("huffman.ParseCode: line %d: escape has no code", lineno)
+			}
+			c.esc = bc
+			c.escMode = EscapeFixed
+			if fields[0] == escapeGammaName {
+				c.escMode = EscapeGamma
+			}
+			continue
+		}
+		s, err := parseSymbol(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("huffman.ParseCode: line %d: %w", lineno, err)
+		}
+		if _, ok := codes[s]; ok {
+			return nil, fmt.Errorf("huffman.ParseCode: line %d: duplicate symbol %d", lineno, s)
+		}
+		codes[s] = bc
+	}
+	// In sorted order, a code that is a prefix of others immediately precedes one of them.
+	slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(a.bits, b.bits) })
+	for i := 1; i < len(entries); i++ {
+		if strings.HasPrefix(entries[i].bits, entries[i-1].bits) {
+			return nil, fmt.Errorf("huffman.ParseCode: lines %d and %d: %s is a prefix of %s",
+				entries[i-1].line, entries[i].line, entries[i-1].bits, entries[i].bits)
+		}
+	}
+	syms := slices.Sorted(maps.Keys(codes))
+	switch {
+	case len(syms) == 0:
+	case syms[len(syms)-1] >= sparseThreshold:
+		c.syms = []Symbol{}
+		for _, s := range syms {
+			if bc := codes[s]; bc.len != 0 {
+				c.syms = append(c.syms, s)
+				c.codes = append(c.codes, bc)
+			}
+		}
+	default:
+		c.codes = make([]bitcode, syms[len(syms)-1]+1)
+		for s, bc := range codes {
+			c.codes[s] = bc
+		}
+	}
+	return c, nil
+}
+
+// parseSymbol parses a decimal number or a Go character literal.
+func parseSymbol(s string) (Symbol, error) {
+	if strings.HasPrefix(s, "'") {
+		r, err := strconv.Unquote(s)
+		if err != nil || len([]rune(r)) != 1 {
+			return 0, fmt.Errorf("bad character literal %s", s)
+		}
+		return Symbol([]rune(r)[0]), nil
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, errors.New("bad symbol " + strconv.Quote(s))
+	}
+	return Symbol(n), nil
+}
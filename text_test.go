@@ -0,0 +1,145 @@
+// Copyright 2025 Jonathan Amsterdam. All rights reserved.
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package huffman
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCodeFormat(t *testing.T) {
+	code, err := NewCodeFromLengthsWithOptions([]uint8{2, 0, 1, 3, 0, 3}, CodeOptions{Escape: EscapeGamma})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Canonical codes, in the order written:
+	// 2: 0, 0: 10, 3: 110, escape: 111.
+	want := `0 2 10
+2 1 0
+3 3 110
+escape-gamma 3 111
+`
+	if got := code.String(); got != want {
+		t.Errorf("String:\ngot\n%s\nwant\n%s", got, want)
+	}
+	wantAll := `0 2 10
+1 0 -
+2 1 0
+3 3 110
+4 0 -
+escape-gamma 3 111
+`
+	if got := fmt.Sprintf("%+v", code); got != wantAll {
+		t.Errorf("%%+v:\ngot\n%s\nwant\n%s", got, wantAll)
+	}
+	if got, want := fmt.Sprintf("%d", code), "%!d(*huffman.Code)"; got != want {
+		t.Errorf("%%d: got %q, want %q", got, want)
+	}
+
+	// ParseCode inverts both forms. The short form omits trailing symbols
+	// without codes.
+	got, err := ParseCode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want {
+		t.Errorf("ParseCode(String()) = %v, want %v", got, code)
+	}
+	got, err = ParseCode(wantAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.codes, code.codes) || got.esc != code.esc || got.escMode != code.escMode {
+		t.Errorf("ParseCode(%%+v) = %+v, want %+v", got, code)
+	}
+
+	input, err := os.ReadFile(filepath.Join("testdata", "pride-and-prejudice.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := NewCodeBuilder(nil)
+	cb.Write(input)
+	pride, err := cb.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = ParseCode(fmt.Sprintf("%+v", pride))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.codes, pride.codes) {
+		t.Error("pride code: ParseCode(String()) differs")
+	}
+}
+
+func TestParseCode(t *testing.T) {
+	code, err := ParseCode(`
+		# A non-canonical code.
+		'a' 1
+		'b' 2 01
+		'€' 00
+		escape 3 000
+	`)
+	if err == nil {
+		t.Fatalf("got %v, want prefix error", code)
+	}
+	code, err = ParseCode(`
+		# A non-canonical code.
+		'a' 1
+		'b' 2 01
+		'€' 001
+		escape 000
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[Symbol]bitcode{'a': {1, 1}, 'b': {0b10, 2}, '€': {0b100, 3}}
+	for s, bc := range code.codes {
+		if bc != want[Symbol(s)] {
+			t.Errorf("symbol %d: got %v, want %v", s, bc, want[Symbol(s)])
+		}
+	}
+	if code.escMode != EscapeFixed || code.esc != (bitcode{0, 3}) {
+		t.Errorf("got escape %v %s", code.esc, code.escMode)
+	}
+
+	// Character literals may contain whitespace.
+	code, err = ParseCode("' ' 1\n'\t' 01\n'\\n' 2 00\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for s, w := range map[Symbol]bitcode{' ': {1, 1}, '\t': {0b10, 2}, '\n': {0, 2}} {
+		if g := code.code(s); g != w {
+			t.Errorf("symbol %q: got %v, want %v", rune(s), g, w)
+		}
+	}
+
+	for _, text := range []string{
+		"1",
+		"1 2 3 4",
+		"x 1",
+		"'ab' 1",
+		"' 1",
+		"-1 1",
+		"1 2 0",
+		"1 0 1",
+		"1 1 2",
+		"1 x 1",
+		"1 123456789012345678901",
+		"1 0\n1 1",
+		"escape -",
+		"escape 0\nescape-gamma 1",
+		"1 0\n2 01\n3 1",
+	} {
+		if _, err := ParseCode(text); err == nil {
+			t.Errorf("%q: got nil, want error", text)
+		} else {
+			t.Logf("%q: %v", text, err)
+		}
+	}
+}